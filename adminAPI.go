@@ -0,0 +1,205 @@
+package geoblock
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	lru "github.com/PascalMinder/geoblock/lrucache"
+)
+
+// adminAPI serves the opt-in endpoint to inspect, purge and seed the shared
+// IP cache. Requests must come directly (RemoteAddr, not X-Forwarded-For)
+// from an allowed network and carry the configured bearer token.
+type adminAPI struct {
+	path        string
+	token       string
+	allowedNets []*net.IPNet
+	cache       *lru.LRUCache
+	persist     *CachePersist
+	name        string
+	logger      *log.Logger
+}
+
+// adminEntry is the JSON representation of a cached IP lookup.
+type adminEntry struct {
+	IP          string    `json:"ip"`
+	Country     string    `json:"country"`
+	ASN         uint32    `json:"asn,omitempty"`
+	Subdivision string    `json:"subdivision,omitempty"`
+	Timestamp   time.Time `json:"timestamp"`
+	Pinned      bool      `json:"pinned"`
+}
+
+// adminSummary is returned for a GET without an IP address.
+type adminSummary struct {
+	Size   int `json:"size"`
+	Length int `json:"length"`
+}
+
+func buildAdminAPI(
+	config *Config, cache *lru.LRUCache, persist *CachePersist, logger *log.Logger, name string,
+) (*adminAPI, error) {
+	if len(config.AdminAPIPath) == 0 {
+		return nil, nil
+	}
+	if !strings.HasPrefix(config.AdminAPIPath, "/") {
+		return nil, fmt.Errorf("admin API path must start with '/': %s", config.AdminAPIPath)
+	}
+	if len(config.AdminAPIToken) == 0 {
+		return nil, fmt.Errorf("admin API path configured without admin API token")
+	}
+
+	allowedNets := initPrivateIPBlocks()
+	if len(config.AdminAPIAllowedIPs) > 0 {
+		var err error
+		if allowedNets, err = parseIPNets(config.AdminAPIAllowedIPs); err != nil {
+			return nil, fmt.Errorf("admin API allowed IPs: %w", err)
+		}
+	}
+
+	return &adminAPI{
+		path:        config.AdminAPIPath,
+		token:       config.AdminAPIToken,
+		allowedNets: allowedNets,
+		cache:       cache,
+		persist:     persist,
+		name:        name,
+		logger:      logger,
+	}, nil
+}
+
+func (api *adminAPI) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if !api.isAllowedSource(req) {
+		api.logger.Printf("%s: admin API request rejected from [%s]: source not allowed", api.name, req.RemoteAddr)
+		http.Error(rw, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if !api.isAuthorized(req) {
+		api.logger.Printf("%s: admin API request rejected from [%s]: invalid token", api.name, req.RemoteAddr)
+		rw.Header().Set("WWW-Authenticate", `Bearer realm="geoblock"`)
+		http.Error(rw, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	ipParam := strings.TrimSpace(req.URL.Query().Get("ip"))
+	if ipParam == "" {
+		api.serveCache(rw, req)
+		return
+	}
+
+	ip := net.ParseIP(ipParam)
+	if ip == nil {
+		http.Error(rw, fmt.Sprintf("invalid IP address [%s]", ipParam), http.StatusBadRequest)
+		return
+	}
+
+	api.serveEntry(rw, req, ip.String())
+}
+
+// serveCache handles requests for the whole cache.
+func (api *adminAPI) serveCache(rw http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		writeJSON(rw, http.StatusOK, adminSummary{Size: api.cache.Size(), Length: api.cache.Length()})
+	case http.MethodDelete:
+		api.cache.Purge()
+		api.persist.MarkDirty()
+		api.logger.Printf("%s: admin API purged the IP cache", api.name)
+		rw.WriteHeader(http.StatusNoContent)
+	default:
+		rw.Header().Set("Allow", "GET, DELETE")
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// serveEntry handles requests for a single IP address.
+func (api *adminAPI) serveEntry(rw http.ResponseWriter, req *http.Request, ip string) {
+	switch req.Method {
+	case http.MethodGet:
+		value, ok := api.cache.Peek(ip)
+		entry, isEntry := value.(ipEntry)
+		if !ok || !isEntry {
+			http.Error(rw, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		writeJSON(rw, http.StatusOK, newAdminEntry(ip, entry))
+
+	case http.MethodDelete:
+		if !api.cache.Remove(ip) {
+			http.Error(rw, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		api.persist.MarkDirty()
+		api.logger.Printf("%s: admin API removed [%s] from the IP cache", api.name, ip)
+		rw.WriteHeader(http.StatusNoContent)
+
+	case http.MethodPut:
+		country := strings.ToUpper(strings.TrimSpace(req.URL.Query().Get("country")))
+		if !isCountryCode(country) {
+			http.Error(rw, fmt.Sprintf("invalid country code [%s]", country), http.StatusBadRequest)
+			return
+		}
+		entry := ipEntry{Country: country, Timestamp: time.Now(), Pinned: true}
+		api.cache.Add(ip, entry)
+		api.persist.MarkDirty()
+		api.logger.Printf("%s: admin API pinned [%s] to country [%s]", api.name, ip, country)
+		writeJSON(rw, http.StatusOK, newAdminEntry(ip, entry))
+
+	default:
+		rw.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (api *adminAPI) isAllowedSource(req *http.Request) bool {
+	ip, err := parseIP(req.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	for _, ipNet := range api.allowedNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (api *adminAPI) isAuthorized(req *http.Request) bool {
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(api.token)) == 1
+}
+
+func newAdminEntry(ip string, entry ipEntry) adminEntry {
+	return adminEntry{
+		IP: ip, Country: entry.Country, ASN: entry.ASN, Subdivision: entry.Subdivision,
+		Timestamp: entry.Timestamp, Pinned: entry.Pinned,
+	}
+}
+
+func writeJSON(rw http.ResponseWriter, status int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	_ = json.NewEncoder(rw).Encode(v)
+}
+
+// isCountryCode reports whether code looks like an ISO 3166-1 alpha-2 code.
+func isCountryCode(code string) bool {
+	if len(code) != countryCodeLength {
+		return false
+	}
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
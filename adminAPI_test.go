@@ -0,0 +1,149 @@
+package geoblock_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	geoblock "github.com/PascalMinder/geoblock"
+)
+
+const (
+	adminAPIPath  = "/_geoblock/cache"
+	adminAPIToken = "s3cr3t"
+)
+
+func createAdminAPIHandler(t *testing.T) http.Handler {
+	t.Helper()
+
+	apiStub := httptest.NewServer(&CountryCodeHandler{ResponseCountryCode: "CA"})
+	t.Cleanup(apiStub.Close)
+
+	cfg := createTesterConfig()
+	cfg.API = apiStub.URL + "/{ip}"
+	cfg.Countries = append(cfg.Countries, "CH")
+	cfg.AdminAPIPath = adminAPIPath
+	cfg.AdminAPIToken = adminAPIToken
+
+	ctx := context.Background()
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+
+	handler, err := geoblock.New(ctx, next, cfg, t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return handler
+}
+
+func adminRequest(handler http.Handler, method, query, remoteAddr, token string) *http.Response {
+	req := httptest.NewRequest(method, "http://localhost"+adminAPIPath+query, nil)
+	req.RemoteAddr = remoteAddr
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder.Result()
+}
+
+func geoblockRequest(handler http.Handler, ip string) *http.Response {
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.Header.Add(xForwardedFor, ip)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder.Result()
+}
+
+func TestAdminAPIRejectsUnauthorizedRequests(t *testing.T) {
+	handler := createAdminAPIHandler(t)
+
+	// public source address, valid token
+	assertStatusCode(t, adminRequest(handler, http.MethodGet, "", "203.0.113.1:4711", adminAPIToken), http.StatusForbidden)
+	// allowed source address, missing and wrong token
+	assertStatusCode(t, adminRequest(handler, http.MethodGet, "", "127.0.0.1:4711", ""), http.StatusUnauthorized)
+	assertStatusCode(t, adminRequest(handler, http.MethodGet, "", "127.0.0.1:4711", "wrong"), http.StatusUnauthorized)
+}
+
+func TestAdminAPIPinInspectAndDeleteEntry(t *testing.T) {
+	handler := createAdminAPIHandler(t)
+	const remote = "127.0.0.1:4711"
+
+	// The API resolves the IP to CA, which is not allowed.
+	assertStatusCode(t, geoblockRequest(handler, caExampleIP), http.StatusForbidden)
+
+	resp := adminRequest(handler, http.MethodGet, "?ip="+caExampleIP, remote, adminAPIToken)
+	assertStatusCode(t, resp, http.StatusOK)
+
+	var entry struct {
+		IP      string `json:"ip"`
+		Country string `json:"country"`
+		Pinned  bool   `json:"pinned"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&entry); err != nil {
+		t.Fatalf("decode entry failed: %v", err)
+	}
+	if entry.IP != caExampleIP || entry.Country != "CA" || entry.Pinned {
+		t.Fatalf("unexpected cached entry: %+v", entry)
+	}
+
+	// Pin the IP to an allowed country.
+	resp = adminRequest(handler, http.MethodPut, "?ip="+caExampleIP+"&country=ch", remote, adminAPIToken)
+	assertStatusCode(t, resp, http.StatusOK)
+	assertStatusCode(t, geoblockRequest(handler, caExampleIP), http.StatusOK)
+
+	resp = adminRequest(handler, http.MethodGet, "?ip="+caExampleIP, remote, adminAPIToken)
+	if err := json.NewDecoder(resp.Body).Decode(&entry); err != nil {
+		t.Fatalf("decode entry failed: %v", err)
+	}
+	if entry.Country != "CH" || !entry.Pinned {
+		t.Fatalf("expected pinned CH entry, got %+v", entry)
+	}
+
+	assertStatusCode(t, adminRequest(handler, http.MethodDelete, "?ip="+caExampleIP, remote, adminAPIToken),
+		http.StatusNoContent)
+	assertStatusCode(t, adminRequest(handler, http.MethodGet, "?ip="+caExampleIP, remote, adminAPIToken),
+		http.StatusNotFound)
+}
+
+func TestAdminAPIPurgeAndValidation(t *testing.T) {
+	handler := createAdminAPIHandler(t)
+	const remote = "[::1]:4711"
+
+	assertStatusCode(t, geoblockRequest(handler, caExampleIP), http.StatusForbidden)
+
+	assertStatusCode(t, adminRequest(handler, http.MethodPut, "?ip=not-an-ip&country=CH", remote, adminAPIToken),
+		http.StatusBadRequest)
+	assertStatusCode(t, adminRequest(handler, http.MethodPut, "?ip="+caExampleIP+"&country=CHE", remote, adminAPIToken),
+		http.StatusBadRequest)
+	assertStatusCode(t, adminRequest(handler, http.MethodPost, "", remote, adminAPIToken), http.StatusMethodNotAllowed)
+
+	assertStatusCode(t, adminRequest(handler, http.MethodDelete, "", remote, adminAPIToken), http.StatusNoContent)
+
+	resp := adminRequest(handler, http.MethodGet, "", remote, adminAPIToken)
+	var summary struct {
+		Length int `json:"length"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&summary); err != nil {
+		t.Fatalf("decode summary failed: %v", err)
+	}
+	if summary.Length != 0 {
+		t.Fatalf("expected empty cache after purge, got %d entries", summary.Length)
+	}
+}
+
+func TestAdminAPIRequiresToken(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.Countries = append(cfg.Countries, "CH")
+	cfg.AdminAPIPath = adminAPIPath
+
+	ctx := context.Background()
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+
+	if _, err := geoblock.New(ctx, next, cfg, t.Name()); err == nil {
+		t.Fatal("expected error for admin API without token")
+	}
+}
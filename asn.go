@@ -0,0 +1,385 @@
+package geoblock
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PascalMinder/geoblock/mmdb"
+)
+
+const (
+	asnHeader = "X-IPASN"
+
+	// maxJSONAPIResponseSize limits the response read from an ASN or
+	// subdivision API.
+	maxJSONAPIResponseSize = 64 << 10
+)
+
+// asnResolver resolves the autonomous system number of an IP address.
+// An ASN of 0 means unknown (e.g. not routed).
+type asnResolver interface {
+	lookupASN(ip net.IP) (uint32, error)
+}
+
+// asnRules are the ASN source and the allowed and denied ASNs.
+type asnRules struct {
+	resolver asnResolver
+	allowed  map[uint32]bool
+	denied   map[uint32]bool
+}
+
+func buildASNRules(config *Config) (*asnRules, error) {
+	allowed, err := parseASNSet(config.AllowedASNs)
+	if err != nil {
+		return nil, fmt.Errorf("allowed ASNs: %w", err)
+	}
+
+	denied, err := parseASNSet(config.DeniedASNs)
+	if err != nil {
+		return nil, fmt.Errorf("denied ASNs: %w", err)
+	}
+
+	resolver, err := buildASNResolver(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &asnRules{resolver: resolver, allowed: allowed, denied: denied}, nil
+}
+
+// buildASNResolver creates the resolver for the configured ASN source, or
+// nil if none is configured.
+func buildASNResolver(config *Config) (asnResolver, error) {
+	switch {
+	case len(config.ASNDatabasePath) != 0:
+		return openASNDatabase(config.ASNDatabasePath)
+	case len(config.ASNAPI) != 0:
+		return &asnAPI{
+			uri:     config.ASNAPI,
+			field:   config.ASNAPIField,
+			timeout: time.Duration(config.APITimeoutMs) * time.Millisecond,
+		}, nil
+	default:
+		return nil, nil
+	}
+}
+
+func validateASNConfig(config *Config) []error {
+	var errs []error
+
+	for _, entries := range [][]string{config.AllowedASNs, config.DeniedASNs} {
+		for _, entry := range entries {
+			if _, err := parseASN(entry); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	hasDatabase, hasAPI := len(config.ASNDatabasePath) != 0, len(config.ASNAPI) != 0
+	switch {
+	case hasDatabase && hasAPI:
+		errs = append(errs, errors.New("asnDatabasePath and asnApi are mutually exclusive"))
+	case hasAPI && !strings.Contains(config.ASNAPI, "{ip}"):
+		errs = append(errs, errors.New("asnApi must contain the {ip} placeholder"))
+	case hasAPI && len(config.ASNAPIField) == 0:
+		errs = append(errs, errors.New("asnApi requires asnApiField"))
+	case !hasDatabase && !hasAPI && (len(config.AllowedASNs) != 0 || len(config.DeniedASNs) != 0 || config.AddASNHeader):
+		errs = append(errs, errors.New("ASN rules or header configured without asnDatabasePath or asnApi"))
+	}
+
+	return errs
+}
+
+// parseASN parses an AS number with an optional "AS" prefix, e.g. "AS13335".
+func parseASN(entry string) (uint32, error) {
+	entry = strings.TrimSpace(entry)
+	digits := strings.TrimPrefix(strings.ToUpper(entry), "AS")
+	asn, err := strconv.ParseUint(digits, 10, 32)
+	if err != nil || asn == 0 {
+		return 0, fmt.Errorf("invalid ASN [%s]", entry)
+	}
+	return uint32(asn), nil
+}
+
+func parseASNSet(entries []string) (map[uint32]bool, error) {
+	asns := make(map[uint32]bool, len(entries))
+	for _, entry := range entries {
+		asn, err := parseASN(entry)
+		if err != nil {
+			return nil, err
+		}
+		asns[asn] = true
+	}
+	return asns, nil
+}
+
+// openASNDatabase opens an offline ASN database: a MaxMind DB (.mmdb) or a
+// range table (.csv, .tsv).
+func openASNDatabase(path string) (asnResolver, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mmdb":
+		reader, err := mmdb.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("ASN database: %w", err)
+		}
+		return &mmdbASNDatabase{reader: reader}, nil
+
+	case ".csv", ".tsv":
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("ASN database: %w", err)
+		}
+		comma := ','
+		if strings.EqualFold(filepath.Ext(path), ".tsv") {
+			comma = '\t'
+		}
+		table, err := parseASNRangeTable(data, comma)
+		if err != nil {
+			return nil, fmt.Errorf("ASN database %s: %w", path, err)
+		}
+		return table, nil
+
+	default:
+		return nil, fmt.Errorf("ASN database: unknown file type [%s], expected .mmdb, .csv or .tsv", path)
+	}
+}
+
+// mmdbASNDatabase resolves ASNs from a MaxMind ASN database (e.g. GeoLite2-ASN).
+type mmdbASNDatabase struct {
+	reader *mmdb.Reader
+}
+
+func (d *mmdbASNDatabase) lookupASN(ip net.IP) (uint32, error) {
+	record, ok, err := d.reader.Lookup(ip)
+	if err != nil || !ok {
+		return 0, err
+	}
+	value, _ := mmdb.Value(record, "autonomous_system_number")
+	asn, _ := mmdb.Uint(value)
+	return uint32(asn), nil
+}
+
+// asnRangeTable resolves ASNs from a table of IP ranges sorted by start.
+type asnRangeTable struct {
+	ranges []asnRange
+}
+
+type asnRange struct {
+	start net.IP // 16-byte form
+	end   net.IP
+	asn   uint32
+}
+
+// parseASNRangeTable parses rows of either "network,asn[,...]" (e.g.
+// GeoLite2-ASN-Blocks) or "start,end,asn[,...]" (e.g. iptoasn.com). A
+// header row, comment rows and rows with ASN 0 (not routed) are skipped.
+func parseASNRangeTable(data []byte, comma rune) (*asnRangeTable, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comma = comma
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	table := &asnRangeTable{}
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		row, err := parseASNRange(record)
+		if err != nil {
+			if line == 1 {
+				continue // header
+			}
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if row.asn != 0 {
+			table.ranges = append(table.ranges, row)
+		}
+	}
+
+	sort.Slice(table.ranges, func(i, j int) bool {
+		return bytes.Compare(table.ranges[i].start, table.ranges[j].start) < 0
+	})
+	return table, nil
+}
+
+func parseASNRange(record []string) (asnRange, error) {
+	if len(record) >= 2 && strings.Contains(record[0], "/") {
+		_, ipNet, err := net.ParseCIDR(record[0])
+		if err != nil {
+			return asnRange{}, err
+		}
+		asn, err := parseTableASN(record[1])
+		if err != nil {
+			return asnRange{}, err
+		}
+		return asnRange{start: ipNet.IP.To16(), end: lastIP(ipNet), asn: asn}, nil
+	}
+
+	if len(record) < 3 {
+		return asnRange{}, fmt.Errorf("expected network,asn or start,end,asn, got %d fields", len(record))
+	}
+	start, end := net.ParseIP(record[0]), net.ParseIP(record[1])
+	if start == nil || end == nil || bytes.Compare(start.To16(), end.To16()) > 0 {
+		return asnRange{}, fmt.Errorf("invalid IP range [%s - %s]", record[0], record[1])
+	}
+	asn, err := parseTableASN(record[2])
+	if err != nil {
+		return asnRange{}, err
+	}
+	return asnRange{start: start.To16(), end: end.To16(), asn: asn}, nil
+}
+
+// parseTableASN parses an ASN column, where 0 is allowed.
+func parseTableASN(field string) (uint32, error) {
+	digits := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(field)), "AS")
+	asn, err := strconv.ParseUint(digits, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ASN [%s]", field)
+	}
+	return uint32(asn), nil
+}
+
+// lastIP returns the last address of the network in 16-byte form.
+func lastIP(ipNet *net.IPNet) net.IP {
+	ip := make(net.IP, len(ipNet.IP))
+	for i := range ipNet.IP {
+		ip[i] = ipNet.IP[i] | ^ipNet.Mask[i]
+	}
+	return ip.To16()
+}
+
+func (t *asnRangeTable) lookupASN(ip net.IP) (uint32, error) {
+	key := ip.To16()
+	if key == nil {
+		return 0, fmt.Errorf("invalid IP address [%s]", ip)
+	}
+
+	// first range starting after the IP address; the candidate precedes it
+	i := sort.Search(len(t.ranges), func(i int) bool {
+		return bytes.Compare(t.ranges[i].start, key) > 0
+	})
+	if i == 0 {
+		return 0, nil
+	}
+	if candidate := t.ranges[i-1]; bytes.Compare(key, candidate.end) <= 0 {
+		return candidate.asn, nil
+	}
+	return 0, nil
+}
+
+// asnAPI resolves ASNs from a JSON API, e.g. "https://ipinfo.example/{ip}"
+// with the field "asn.asn".
+type asnAPI struct {
+	uri     string
+	field   string
+	timeout time.Duration
+}
+
+func (a *asnAPI) lookupASN(ip net.IP) (uint32, error) {
+	value, err := fetchJSONField(a.uri, a.field, a.timeout, ip)
+	if err != nil {
+		return 0, fmt.Errorf("ASN API: %w", err)
+	}
+
+	switch value := value.(type) {
+	case float64:
+		if value < 0 || value > float64(^uint32(0)) || value != float64(uint32(value)) {
+			return 0, fmt.Errorf("ASN API response: invalid ASN [%v]", value)
+		}
+		return uint32(value), nil
+	case string:
+		// e.g. "AS13335 Cloudflare, Inc."
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			return 0, fmt.Errorf("ASN API response: empty ASN")
+		}
+		return parseTableASN(fields[0])
+	default:
+		return 0, fmt.Errorf("ASN API response: invalid ASN [%v]", value)
+	}
+}
+
+// fetchJSONField queries a JSON API for the IP address and returns the value
+// of the dot separated field, e.g. "asn.asn".
+func fetchJSONField(uri, field string, timeout time.Duration, ip net.IP) (interface{}, error) {
+	client := http.Client{Timeout: timeout}
+	res, err := client.Get(strings.Replace(uri, "{ip}", ip.String(), 1))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("response status code: %d", res.StatusCode)
+	}
+
+	var document interface{}
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxJSONAPIResponseSize)).Decode(&document); err != nil {
+		return nil, err
+	}
+
+	values, err := jsonPathValues(document, strings.Split(field, "."))
+	if err != nil || len(values) != 1 {
+		return nil, fmt.Errorf("field [%s] not found", field)
+	}
+	return values[0], nil
+}
+
+// lookupASN resolves the ASN of the IP address. A failed lookup is logged
+// and yields 0, so only the country rules apply to the request.
+func (a *GeoBlock) lookupASN(ip net.IP) uint32 {
+	if a.asnResolver == nil {
+		return 0
+	}
+
+	asn, err := a.asnResolver.lookupASN(ip)
+	if err != nil {
+		a.infoLogger.Printf("%s: failed to resolve ASN of [%s]: %v", a.name, ip, err)
+		return 0
+	}
+	return asn
+}
+
+// allowDenyEntry decides on the ASN and country of a request IP address.
+// A denied ASN takes precedence over an allowed ASN; both take precedence
+// over the country policies and the country rules.
+func (a *GeoBlock) allowDenyEntry(requestIPAddr *net.IP, entry ipEntry) decision {
+	if a.deniedASNs[entry.ASN] {
+		a.infoLogger.Printf("%s: request denied [%s] for ASN [AS%d] due to: %s", a.name, requestIPAddr, entry.ASN, reasonDeniedASN)
+		return decision{allowed: false, reason: reasonDeniedASN, entry: entry}
+	}
+
+	if a.allowedASNs[entry.ASN] {
+		if a.logAllowedRequests {
+			a.infoLogger.Printf("%s: request allowed [%s] for ASN [AS%d]", a.name, requestIPAddr, entry.ASN)
+		}
+		return decision{allowed: true, reason: reasonAllowedASN, entry: entry}
+	}
+
+	if policy, code := a.countryPolicy(entry); policy != nil {
+		result := a.applyCountryPolicy(requestIPAddr, policy, code)
+		result.entry = entry
+		return result
+	}
+
+	allowed, reason := a.allowDenyCountry(requestIPAddr, entry.Country, entry.Subdivision)
+	return decision{allowed: allowed, reason: reason, entry: entry}
+}
@@ -0,0 +1,258 @@
+package geoblock_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	geoblock "github.com/PascalMinder/geoblock"
+)
+
+const (
+	asnHeader    = "X-IPASN"
+	asnRangeData = `network,autonomous_system_number,autonomous_system_organization
+82.220.0.0/16,15796,Salt Mobile SA
+# ranges can also be written as start and end address
+99.220.0.0,99.220.255.255,AS812
+`
+)
+
+func createASNHandler(t *testing.T, cfg *geoblock.Config) http.Handler {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/"+chExampleIP {
+			_, _ = rw.Write([]byte("CH"))
+			return
+		}
+		_, _ = rw.Write([]byte("CA"))
+	}))
+	t.Cleanup(server.Close)
+
+	path := filepath.Join(t.TempDir(), "asn.csv")
+	if err := os.WriteFile(path, []byte(asnRangeData), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.API = server.URL + "/{ip}"
+	cfg.ASNDatabasePath = path
+
+	ctx := context.Background()
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+
+	handler, err := geoblock.New(ctx, next, cfg, t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return handler
+}
+
+func TestDeniedASNOverridesAllowedCountry(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.Countries = append(cfg.Countries, "CH", "CA")
+	cfg.DeniedASNs = []string{"AS15796"}
+
+	handler := createASNHandler(t, cfg)
+
+	assertStatusCode(t, geoblockRequest(handler, chExampleIP), http.StatusForbidden)
+	assertStatusCode(t, geoblockRequest(handler, caExampleIP), http.StatusOK)
+}
+
+func TestAllowedASNOverridesDeniedCountry(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.Countries = append(cfg.Countries, "CH")
+	cfg.AllowedASNs = []string{"812"}
+
+	handler := createASNHandler(t, cfg)
+
+	assertStatusCode(t, geoblockRequest(handler, caExampleIP), http.StatusOK)
+	// not covered by the range table, the country rules apply
+	assertStatusCode(t, geoblockRequest(handler, "99.221.0.1"), http.StatusForbidden)
+}
+
+func TestASNHeader(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.Countries = append(cfg.Countries, "CH", "CA")
+	cfg.AddASNHeader = true
+
+	handler := createASNHandler(t, cfg)
+
+	for ip, expected := range map[string]string{chExampleIP: "15796", "99.221.0.1": ""} {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.Header.Add(xForwardedFor, ip)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		assertStatusCode(t, recorder.Result(), http.StatusOK)
+		assertRequestHeader(t, req, asnHeader, expected)
+	}
+}
+
+func TestASNFromAPI(t *testing.T) {
+	asnServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		_, _ = rw.Write([]byte(`{"ip": "99.220.109.148", "asn": {"asn": "AS812 Rogers Communications"}}`))
+	}))
+	defer asnServer.Close()
+
+	countryServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		_, _ = rw.Write([]byte("CA"))
+	}))
+	defer countryServer.Close()
+
+	cfg := createTesterConfig()
+	cfg.API = countryServer.URL + "/{ip}"
+	cfg.Countries = append(cfg.Countries, "CA")
+	cfg.ASNAPI = asnServer.URL + "/{ip}"
+	cfg.ASNAPIField = "asn.asn"
+	cfg.DeniedASNs = []string{"AS812"}
+
+	ctx := context.Background()
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+
+	handler, err := geoblock.New(ctx, next, cfg, t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertStatusCode(t, geoblockRequest(handler, caExampleIP), http.StatusForbidden)
+}
+
+func TestCachedASNIsUsed(t *testing.T) {
+	var asnCalls int32
+	asnServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&asnCalls, 1)
+		_, _ = rw.Write([]byte(`{"asn": 15796}`))
+	}))
+	defer asnServer.Close()
+
+	path := filepath.Join(t.TempDir(), "seed.csv")
+	seed := strings.Join([]string{
+		"ip,country,timestamp,pinned,asn",
+		chExampleIP + ",CH," + time.Now().UTC().Format(time.RFC3339) + ",false,64500",
+	}, "\n")
+	if err := os.WriteFile(path, []byte(seed), 0o600); err != nil {
+		t.Fatalf("seed csv failed: %v", err)
+	}
+
+	cfg := createTesterConfig()
+	cfg.Countries = append(cfg.Countries, "CH")
+	cfg.IPDatabaseCachePath = path
+	cfg.ASNAPI = asnServer.URL + "/{ip}"
+	cfg.ASNAPIField = "asn"
+	cfg.DeniedASNs = []string{"AS64500"}
+
+	ctx := context.Background()
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+
+	handler, err := geoblock.New(ctx, next, cfg, t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertStatusCode(t, geoblockRequest(handler, chExampleIP), http.StatusForbidden)
+
+	if got := atomic.LoadInt32(&asnCalls); got != 0 {
+		t.Fatalf("expected the cached ASN to be used, got %d ASN API calls", got)
+	}
+}
+
+func TestMissingASNNotRefetchedFromAPI(t *testing.T) {
+	var asnCalls int32
+	asnServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&asnCalls, 1)
+		_, _ = rw.Write([]byte(`{"asn": 0}`))
+	}))
+	defer asnServer.Close()
+
+	// an unrouted IP address or a failed lookup is cached without ASN
+	path := filepath.Join(t.TempDir(), "seed.csv")
+	seed := strings.Join([]string{
+		"ip,country,timestamp,pinned,asn",
+		chExampleIP + ",CH," + time.Now().UTC().Format(time.RFC3339) + ",false,0",
+	}, "\n")
+	if err := os.WriteFile(path, []byte(seed), 0o600); err != nil {
+		t.Fatalf("seed csv failed: %v", err)
+	}
+
+	cfg := createTesterConfig()
+	cfg.Countries = append(cfg.Countries, "CH")
+	cfg.IPDatabaseCachePath = path
+	cfg.ASNAPI = asnServer.URL + "/{ip}"
+	cfg.ASNAPIField = "asn"
+
+	ctx := context.Background()
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+
+	handler, err := geoblock.New(ctx, next, cfg, t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		assertStatusCode(t, geoblockRequest(handler, chExampleIP), http.StatusOK)
+	}
+
+	if got := atomic.LoadInt32(&asnCalls); got != 0 {
+		t.Fatalf("expected no ASN API calls for cache hits, got %d", got)
+	}
+}
+
+func TestInvalidASNConfig(t *testing.T) {
+	for name, modify := range map[string]func(cfg *geoblock.Config){
+		"invalid ASN": func(cfg *geoblock.Config) {
+			cfg.ASNDatabasePath = "asn.csv"
+			cfg.DeniedASNs = []string{"ASX"}
+		},
+		"rules without source": func(cfg *geoblock.Config) { cfg.AllowedASNs = []string{"AS13335"} },
+		"both sources": func(cfg *geoblock.Config) {
+			cfg.ASNDatabasePath = "asn.mmdb"
+			cfg.ASNAPI = "https://ipinfo.example/{ip}"
+			cfg.ASNAPIField = "asn"
+		},
+		"api without field":     func(cfg *geoblock.Config) { cfg.ASNAPI = "https://ipinfo.example/{ip}" },
+		"missing database file": func(cfg *geoblock.Config) { cfg.ASNDatabasePath = "/does/not/exist.mmdb" },
+	} {
+		cfg := createTesterConfig()
+		cfg.Countries = append(cfg.Countries, "CH")
+		modify(cfg)
+
+		ctx := context.Background()
+		next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+
+		if _, err := geoblock.New(ctx, next, cfg, t.Name()); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestAdminAPIShowsASN(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.Countries = append(cfg.Countries, "CH")
+	cfg.AdminAPIPath = adminAPIPath
+	cfg.AdminAPIToken = adminAPIToken
+
+	handler := createASNHandler(t, cfg)
+
+	assertStatusCode(t, geoblockRequest(handler, chExampleIP), http.StatusOK)
+
+	resp := adminRequest(handler, http.MethodGet, "?ip="+chExampleIP, "127.0.0.1:4711", adminAPIToken)
+	assertStatusCode(t, resp, http.StatusOK)
+
+	var entry struct {
+		Country string `json:"country"`
+		ASN     uint32 `json:"asn"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&entry); err != nil {
+		t.Fatalf("decode entry failed: %v", err)
+	}
+	if entry.Country != "CH" || entry.ASN != 15796 {
+		t.Fatalf("unexpected cached entry: %+v", entry)
+	}
+}
@@ -0,0 +1,328 @@
+package geoblock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	lru "github.com/PascalMinder/geoblock/lrucache"
+)
+
+const (
+	defaultBanWindow       = time.Minute
+	defaultBanDuration     = time.Hour
+	defaultBanListSize     = 10000
+	banListPersistInterval = 10 * time.Second
+)
+
+// banList bans IP addresses for a while after repeated denied requests, so
+// the requests of scanners are answered before any lookup or logging.
+type banList struct {
+	mu        sync.Mutex
+	threshold int
+	window    time.Duration
+	duration  time.Duration
+	logEvery  int
+	offenders *lru.LRUCache // IP address string -> *offender
+	now       func() time.Time
+
+	path   string        // persisted ban list, empty if disabled
+	nudge  chan struct{} // signals the persistence worker
+	logger *log.Logger
+	name   string
+}
+
+// offender counts the denied requests of an IP address.
+type offender struct {
+	denials     int
+	windowStart time.Time
+	bannedUntil time.Time
+	blocked     int // requests answered during the current ban
+}
+
+// persistedBan is an entry of the persisted ban list.
+type persistedBan struct {
+	IP    string    `json:"ip"`
+	Until time.Time `json:"until"`
+}
+
+var (
+	sharedBanListsMu sync.Mutex
+	sharedBanLists   = map[string]*banList{}
+)
+
+func validateBanConfig(config *Config) []error {
+	var errs []error
+
+	if config.BanThreshold < 0 {
+		errs = append(errs, fmt.Errorf("invalid ban threshold [%d]", config.BanThreshold))
+	}
+	if config.BanWindowSeconds < 0 {
+		errs = append(errs, fmt.Errorf("invalid ban window [%d] seconds", config.BanWindowSeconds))
+	}
+	if config.BanDurationSeconds < 0 {
+		errs = append(errs, fmt.Errorf("invalid ban duration [%d] seconds", config.BanDurationSeconds))
+	}
+	if config.BanLogEvery < 0 {
+		errs = append(errs, fmt.Errorf("invalid ban log sampling [%d]", config.BanLogEvery))
+	}
+	if config.BanListSize < 0 || config.BanListSize == 1 {
+		errs = append(errs, fmt.Errorf("invalid ban list size [%d], must be at least 2", config.BanListSize))
+	}
+	if len(config.BanListPath) != 0 {
+		if config.BanThreshold == 0 {
+			errs = append(errs, fmt.Errorf("banListPath requires banThreshold"))
+		}
+		if _, err := ValidatePersistencePath(config.BanListPath); err != nil {
+			errs = append(errs, fmt.Errorf("ban list path: %w", err))
+		}
+	}
+
+	return errs
+}
+
+func printBanConfiguration(name string, config *Config, logger *log.Logger) {
+	if config.BanThreshold > 0 {
+		logger.Printf("%s: Ban after %d denied requests within %ds for %ds", name,
+			config.BanThreshold, config.BanWindowSeconds, config.BanDurationSeconds)
+	}
+	if len(config.BanListPath) != 0 {
+		logger.Printf("%s: Ban list path: %s", name, config.BanListPath)
+	}
+}
+
+// buildBanList returns the ban list of the middleware, or nil if bans are
+// disabled. Like the IP cache, one ban list is shared per middleware name, so
+// a ban survives Traefik rebuilding the middleware; a reload applies the new
+// settings to it.
+func buildBanList(config *Config, logger *log.Logger, name string) (*banList, error) {
+	if config.BanThreshold == 0 {
+		return nil, nil
+	}
+
+	window := defaultBanWindow
+	if config.BanWindowSeconds > 0 {
+		window = time.Duration(config.BanWindowSeconds) * time.Second
+	}
+	duration := defaultBanDuration
+	if config.BanDurationSeconds > 0 {
+		duration = time.Duration(config.BanDurationSeconds) * time.Second
+	}
+	size := config.BanListSize
+	if size == 0 {
+		size = defaultBanListSize
+	}
+
+	sharedBanListsMu.Lock()
+	defer sharedBanListsMu.Unlock()
+
+	bans, ok := sharedBanLists[name]
+	if !ok {
+		offenders, err := lru.NewLRUCache(size)
+		if err != nil {
+			return nil, fmt.Errorf("ban list: %w", err)
+		}
+		bans = &banList{offenders: offenders, now: time.Now, nudge: make(chan struct{}, 1), logger: logger, name: name}
+
+		if len(config.BanListPath) != 0 {
+			path, err := ValidatePersistencePath(config.BanListPath)
+			if err != nil {
+				return nil, fmt.Errorf("ban list path: %w", err)
+			}
+			bans.path = path
+			bans.load()
+			go bans.run(context.Background())
+		}
+		sharedBanLists[name] = bans
+	} else if size != bans.offenders.Size() {
+		_, _ = bans.offenders.Resize(size)
+	}
+
+	bans.mu.Lock()
+	bans.threshold, bans.window, bans.duration, bans.logEvery = config.BanThreshold, window, duration, config.BanLogEvery
+	bans.mu.Unlock()
+
+	return bans, nil
+}
+
+// banned reports whether the IP address is banned and whether the request
+// should be logged: only every logEvery-th request during a ban is logged.
+func (b *banList) banned(ip net.IP) (bool, bool) {
+	if b == nil {
+		return false, false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	value, ok := b.offenders.Get(ip.String())
+	if !ok {
+		return false, false
+	}
+	o := value.(*offender)
+	if !b.now().Before(o.bannedUntil) {
+		return false, false
+	}
+
+	o.blocked++
+	return true, b.logEvery > 0 && o.blocked%b.logEvery == 0
+}
+
+// countsTowardBan reports whether a denial with the reason counts toward a
+// ban. Only the configured rules do: a failing geolocation API or a proxy
+// forwarding private IP addresses must not ban legitimate clients.
+func countsTowardBan(reason string) bool {
+	switch reason {
+	case reasonDeniedIP, reasonDeniedASN, reasonCountryNotAllowed, reasonUnknownCountry:
+		return true
+	default:
+		return false
+	}
+}
+
+// recordDenial counts a denied request of the IP address and bans it once
+// the threshold is reached within the window.
+func (b *banList) recordDenial(ip net.IP) {
+	if b == nil || ip == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	key := ip.String()
+	o := &offender{windowStart: now}
+	if value, ok := b.offenders.Get(key); ok {
+		o = value.(*offender)
+	} else {
+		b.offenders.Add(key, o)
+	}
+
+	if now.Sub(o.windowStart) > b.window {
+		o.denials, o.windowStart = 0, now
+	}
+	o.denials++
+	if o.denials < b.threshold {
+		return
+	}
+
+	o.denials, o.blocked, o.bannedUntil = 0, 0, now.Add(b.duration)
+	b.logger.Printf("%s: [%s] banned for %s after %d denied requests", b.name, key, b.duration, b.threshold)
+
+	if len(b.path) != 0 {
+		select {
+		case b.nudge <- struct{}{}:
+		default:
+			// already scheduled
+		}
+	}
+}
+
+// run writes the ban list after new bans, at most once per
+// banListPersistInterval.
+func (b *banList) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-b.nudge:
+		}
+
+		b.save()
+
+		t := time.NewTimer(banListPersistInterval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (b *banList) save() {
+	b.mu.Lock()
+	now := b.now()
+	var bans []persistedBan
+	for _, key := range b.offenders.Keys() {
+		value, ok := b.offenders.Peek(key)
+		if !ok {
+			continue
+		}
+		if until := value.(*offender).bannedUntil; now.Before(until) {
+			bans = append(bans, persistedBan{IP: key.(string), Until: until})
+		}
+	}
+	b.mu.Unlock()
+
+	data, err := json.Marshal(bans)
+	if err != nil {
+		b.logger.Printf("%s: ban list encode error: %v", b.name, err)
+		return
+	}
+	if err := writeFileAtomic(b.path, "bans-*.tmp", data); err != nil {
+		b.logger.Printf("%s: ban list %v", b.name, err)
+	}
+}
+
+// load restores the bans that have not expired yet. A missing file is not an
+// error.
+func (b *banList) load() {
+	data, err := os.ReadFile(b.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			b.logger.Printf("%s: failed to load ban list from %s: %v", b.name, b.path, err)
+		}
+		return
+	}
+
+	var bans []persistedBan
+	if err := json.Unmarshal(data, &bans); err != nil {
+		b.logger.Printf("%s: ignoring ban list %s: %v", b.name, b.path, err)
+		return
+	}
+
+	now := b.now()
+	for _, ban := range bans {
+		if net.ParseIP(ban.IP) == nil || !now.Before(ban.Until) {
+			continue
+		}
+		b.offenders.Add(ban.IP, &offender{windowStart: now, bannedUntil: ban.Until})
+	}
+}
+
+// rejectBanned answers the request with the denied status code if a client
+// IP address is banned.
+func (a *GeoBlock) rejectBanned(rw http.ResponseWriter, req *http.Request) bool {
+	if a.bans == nil {
+		return false
+	}
+
+	requestIPAddresses, err := a.collectRemoteIP(req)
+	if err != nil || len(requestIPAddresses) == 0 {
+		return false
+	}
+	if a.xForwardedForReverseProxy {
+		requestIPAddresses = requestIPAddresses[:1]
+	}
+
+	for _, requestIPAddress := range requestIPAddresses {
+		banned, logged := a.bans.banned(*requestIPAddress)
+		if !banned {
+			continue
+		}
+		if logged {
+			a.infoLogger.Printf("%s: request denied [%s] due to: %s", a.name, requestIPAddress, reasonBanned)
+		}
+		a.writeDeniedResponse(rw, req, a.httpStatusCodeDeniedRequest, decision{reason: reasonBanned, ip: *requestIPAddress})
+		return true
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package geoblock_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	geoblock "github.com/PascalMinder/geoblock"
+)
+
+func createBanHandler(t *testing.T, cfg *geoblock.Config) http.Handler {
+	t.Helper()
+
+	cfg.Countries = append(cfg.Countries, "CH")
+	cfg.ExcludedPathPatterns = []string{"^localhost/health$"}
+	return createCountryAPIHandler(t, cfg, exampleCountries, nil)
+}
+
+func healthRequest(handler http.Handler, ip string) *http.Response {
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/health", nil)
+	req.Header.Add(xForwardedFor, ip)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder.Result()
+}
+
+func TestBanAfterRepeatedDenials(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.BanThreshold = 2
+
+	handler := createBanHandler(t, cfg)
+
+	assertStatusCode(t, healthRequest(handler, caExampleIP), http.StatusOK)
+	assertStatusCode(t, geoblockRequest(handler, caExampleIP), http.StatusForbidden)
+	assertStatusCode(t, healthRequest(handler, caExampleIP), http.StatusOK)
+	assertStatusCode(t, geoblockRequest(handler, caExampleIP), http.StatusForbidden)
+
+	// banned requests are answered before the excluded paths are checked
+	assertStatusCode(t, healthRequest(handler, caExampleIP), http.StatusForbidden)
+	assertStatusCode(t, healthRequest(handler, "99.220.109.149"), http.StatusOK)
+}
+
+func TestBanIsSharedPerMiddleware(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.BanThreshold = 1
+
+	assertStatusCode(t, geoblockRequest(createBanHandler(t, cfg), caExampleIP), http.StatusForbidden)
+
+	// a second instance of the same middleware, e.g. for another router
+	cfg = createTesterConfig()
+	cfg.BanThreshold = 1
+	assertStatusCode(t, healthRequest(createBanHandler(t, cfg), caExampleIP), http.StatusForbidden)
+}
+
+func TestBanIgnoresLookupFailures(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.Countries = append(cfg.Countries, "CH")
+	cfg.ExcludedPathPatterns = []string{"^localhost/health$"}
+	cfg.BanThreshold = 1
+
+	// the API knows no IP address, every lookup fails
+	handler := createCountryAPIHandler(t, cfg, nil, nil)
+
+	// an API outage and denied local IP addresses never lead to a ban
+	for _, ip := range []string{caExampleIP, "10.0.0.1"} {
+		assertStatusCode(t, geoblockRequest(handler, ip), http.StatusForbidden)
+		assertStatusCode(t, geoblockRequest(handler, ip), http.StatusForbidden)
+		assertStatusCode(t, healthRequest(handler, ip), http.StatusOK)
+	}
+}
+
+func TestBanListPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bans.json")
+
+	// the subtests are different middlewares sharing the ban list file
+	t.Run("first", func(t *testing.T) {
+		cfg := createTesterConfig()
+		cfg.BanThreshold = 1
+		cfg.BanListPath = path
+
+		assertStatusCode(t, geoblockRequest(createBanHandler(t, cfg), caExampleIP), http.StatusForbidden)
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if _, err := os.Stat(path); err == nil {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("ban list was not written")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	t.Run("second", func(t *testing.T) {
+		cfg := createTesterConfig()
+		cfg.BanThreshold = 1
+		cfg.BanListPath = path
+
+		handler := createBanHandler(t, cfg)
+		assertStatusCode(t, healthRequest(handler, caExampleIP), http.StatusForbidden)
+		assertStatusCode(t, healthRequest(handler, chExampleIP), http.StatusOK)
+	})
+}
+
+func TestInvalidBanConfig(t *testing.T) {
+	for name, modify := range map[string]func(cfg *geoblock.Config){
+		"threshold": func(cfg *geoblock.Config) { cfg.BanThreshold = -1 },
+		"window":    func(cfg *geoblock.Config) { cfg.BanWindowSeconds = -1 },
+		"duration":  func(cfg *geoblock.Config) { cfg.BanDurationSeconds = -1 },
+		"size":      func(cfg *geoblock.Config) { cfg.BanListSize = 1 },
+		"path without threshold": func(cfg *geoblock.Config) {
+			cfg.BanThreshold = 0
+			cfg.BanListPath = filepath.Join(t.TempDir(), "bans.json")
+		},
+		"path in missing folder": func(cfg *geoblock.Config) { cfg.BanListPath = "/does/not/exist/bans.json" },
+	} {
+		cfg := createTesterConfig()
+		cfg.Countries = append(cfg.Countries, "CH")
+		cfg.BanThreshold = 3
+		modify(cfg)
+
+		if _, err := newCountryAPIHandler(t, cfg, exampleCountries, nil); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,344 @@
+package geoblock
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBypassHeader   = "X-GeoBlock-Bypass"
+	defaultBypassCookie   = "geoblock_bypass"
+	defaultBypassTokenTTL = 7 * 24 * time.Hour
+
+	minBypassSecretLength      = 32
+	minBypassIssueSecretLength = 16
+)
+
+// bypass lets requests carrying a token signed with the bypass secret pass
+// the country rules, e.g. for traveling staff. A token is
+// "<expiry>.<signature>": the expiry in Unix seconds and the unpadded
+// base64url HMAC-SHA256 of the expiry. The optional issue endpoint sets a
+// token cookie for a one-time secret.
+type bypass struct {
+	secret       []byte
+	header       string
+	cookie       string
+	issuePath    string
+	issueSecrets []string
+	used         *usedSecrets
+	ttl          time.Duration
+	now          func() time.Time
+	name         string
+	logger       *log.Logger
+}
+
+// usedSecrets are the SHA-256 hashes of the one-time secrets already
+// exchanged for a cookie. They are saved to path, if set, so a secret stays
+// used across restarts.
+type usedSecrets struct {
+	mu      sync.Mutex
+	secrets map[string]bool
+	path    string
+}
+
+type bypassContextKey struct{}
+
+var (
+	sharedUsedSecretsMu sync.Mutex
+	sharedUsedSecrets   = map[string]*usedSecrets{}
+)
+
+func validateBypassConfig(config *Config) []error {
+	var errs []error
+
+	if len(config.BypassSecret) == 0 {
+		if len(config.BypassIssuePath) != 0 || len(config.BypassIssueSecrets) != 0 {
+			errs = append(errs, fmt.Errorf("bypass token options require bypassSecret"))
+		}
+		return errs
+	}
+
+	if len(config.BypassSecret) < minBypassSecretLength {
+		errs = append(errs, fmt.Errorf("bypass secret must have at least %d characters", minBypassSecretLength))
+	}
+	if len(config.BypassIssuePath) != 0 && !strings.HasPrefix(config.BypassIssuePath, "/") {
+		errs = append(errs, fmt.Errorf("bypass issue path must start with '/': %s", config.BypassIssuePath))
+	}
+	if len(config.BypassIssuePath) != 0 && len(config.BypassIssueSecrets) == 0 {
+		errs = append(errs, fmt.Errorf("bypass issue path configured without bypass issue secrets"))
+	}
+	for i, secret := range config.BypassIssueSecrets {
+		if len(secret) < minBypassIssueSecretLength {
+			errs = append(errs, fmt.Errorf("bypass issue secret %d must have at least %d characters",
+				i+1, minBypassIssueSecretLength))
+		}
+	}
+	if config.BypassTokenTTLSeconds < 0 {
+		errs = append(errs, fmt.Errorf("invalid bypass token TTL [%d] seconds", config.BypassTokenTTLSeconds))
+	}
+
+	return errs
+}
+
+func printBypassConfiguration(name string, config *Config, logger *log.Logger) {
+	logger.Printf("%s: Bypass tokens: %t", name, len(config.BypassSecret) != 0)
+	if len(config.BypassIssuePath) != 0 {
+		logger.Printf("%s: Bypass issue path: %s", name, config.BypassIssuePath)
+	}
+}
+
+func buildBypass(config *Config, logger *log.Logger, name string) *bypass {
+	if len(config.BypassSecret) == 0 {
+		return nil
+	}
+
+	b := &bypass{
+		secret:       []byte(config.BypassSecret),
+		header:       config.BypassHeader,
+		cookie:       config.BypassCookie,
+		issuePath:    config.BypassIssuePath,
+		issueSecrets: config.BypassIssueSecrets,
+		ttl:          time.Duration(config.BypassTokenTTLSeconds) * time.Second,
+		now:          time.Now,
+		name:         name,
+		logger:       logger,
+	}
+	if len(b.header) == 0 {
+		b.header = defaultBypassHeader
+	}
+	if len(b.cookie) == 0 {
+		b.cookie = defaultBypassCookie
+	}
+	if b.ttl == 0 {
+		b.ttl = defaultBypassTokenTTL
+	}
+
+	// share the used secrets per middleware, so a secret cannot be used
+	// once per instance or again after a reload
+	sharedUsedSecretsMu.Lock()
+	defer sharedUsedSecretsMu.Unlock()
+	if b.used = sharedUsedSecrets[name]; b.used == nil {
+		b.used = &usedSecrets{secrets: make(map[string]bool), path: usedSecretsPath(config, name)}
+		b.load()
+		sharedUsedSecrets[name] = b.used
+	}
+
+	return b
+}
+
+// usedSecretsPath returns the path of the used one-time secrets of the
+// middleware, next to the IP database cache or the ban list; empty if
+// neither is configured.
+func usedSecretsPath(config *Config, name string) string {
+	for _, path := range []string{config.IPDatabaseCachePath, config.BanListPath} {
+		if len(path) != 0 {
+			sum := sha256.Sum256([]byte(name))
+			return filepath.Join(filepath.Dir(path), "geoblock-bypass-"+hex.EncodeToString(sum[:8])+".json")
+		}
+	}
+	return ""
+}
+
+// handleBypass serves the issue endpoint and returns true if it did.
+// Otherwise it removes the token from the request and returns the request
+// carrying whether the token is valid, see allowBypassToken. The token
+// header and cookie are never forwarded to the service.
+func (a *GeoBlock) handleBypass(rw http.ResponseWriter, req *http.Request) (*http.Request, bool) {
+	if a.bypass == nil {
+		return req, false
+	}
+
+	if len(a.bypass.issuePath) != 0 && req.URL.Path == a.bypass.issuePath {
+		a.bypass.serveIssue(rw, req)
+		return req, true
+	}
+
+	token := req.Header.Get(a.bypass.header)
+	req.Header.Del(a.bypass.header)
+	if cookie, err := req.Cookie(a.bypass.cookie); err == nil {
+		if len(token) == 0 {
+			token = cookie.Value
+		}
+		a.bypass.stripCookie(req)
+	}
+	if len(token) == 0 || !a.bypass.valid(token) {
+		return req, false
+	}
+
+	return req.WithContext(context.WithValue(req.Context(), bypassContextKey{}, true)), false
+}
+
+// allowBypassToken allows a request with a valid token that was denied by
+// the country rules. Denied IP addresses and ASNs, bans, rate limits and
+// tarpits still apply.
+func (a *GeoBlock) allowBypassToken(requestIPAddr *net.IP, req *http.Request, result decision) decision {
+	if result.allowed {
+		return result
+	}
+	if valid, _ := req.Context().Value(bypassContextKey{}).(bool); !valid {
+		return result
+	}
+	switch result.reason {
+	case reasonCountryNotAllowed, reasonUnknownCountry, reasonLookupFailed:
+	default:
+		return result
+	}
+
+	// always logged, the denial by the country rules has been logged already
+	a.infoLogger.Printf("%s: request allowed [%s] due to: %s", a.name, requestIPAddr, reasonBypassToken)
+	return decision{allowed: true, reason: reasonBypassToken, ip: result.ip, entry: result.entry}
+}
+
+// stripCookie removes the token cookie from the Cookie header, so the token
+// is not forwarded to the service either.
+func (b *bypass) stripCookie(req *http.Request) {
+	var kept []string
+	for _, cookie := range req.Cookies() {
+		if cookie.Name != b.cookie {
+			kept = append(kept, cookie.Name+"="+cookie.Value)
+		}
+	}
+	req.Header.Del("Cookie")
+	if len(kept) > 0 {
+		req.Header.Set("Cookie", strings.Join(kept, "; "))
+	}
+}
+
+// sign returns the token expiring at the given time.
+func (b *bypass) sign(expiry time.Time) string {
+	payload := strconv.FormatInt(expiry.Unix(), 10)
+	return payload + "." + b.signature(payload)
+}
+
+func (b *bypass) signature(payload string) string {
+	mac := hmac.New(sha256.New, b.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// valid reports whether the token is signed with the secret and not expired.
+func (b *bypass) valid(token string) bool {
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(b.signature(payload))) {
+		return false
+	}
+	expiry, err := strconv.ParseInt(payload, 10, 64)
+	if err != nil {
+		return false
+	}
+	return b.now().Unix() < expiry
+}
+
+// serveIssue sets the token cookie if the form field "secret" of a POST
+// request is an unused one-time secret.
+func (b *bypass) serveIssue(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		rw.Header().Set("Allow", http.MethodPost)
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	secret := req.PostFormValue("secret")
+	if !b.useSecret(secret) {
+		b.logger.Printf("%s: bypass cookie refused for [%s]: invalid or used secret", b.name, req.RemoteAddr)
+		http.Error(rw, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	expiry := b.now().Add(b.ttl)
+	http.SetCookie(rw, &http.Cookie{
+		Name:     b.cookie,
+		Value:    b.sign(expiry),
+		Path:     "/",
+		Expires:  expiry,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	b.logger.Printf("%s: bypass cookie issued for [%s] until %s", b.name, req.RemoteAddr, expiry.Format(time.RFC3339))
+	http.Redirect(rw, req, "/", http.StatusSeeOther)
+}
+
+// useSecret marks the secret as used and reports whether it was a valid,
+// unused one-time secret.
+func (b *bypass) useSecret(secret string) bool {
+	if len(secret) == 0 {
+		return false
+	}
+
+	b.used.mu.Lock()
+	defer b.used.mu.Unlock()
+
+	for _, candidate := range b.issueSecrets {
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(candidate)) == 1 {
+			sum := sha256.Sum256([]byte(candidate))
+			hash := hex.EncodeToString(sum[:])
+			if b.used.secrets[hash] {
+				return false
+			}
+			b.used.secrets[hash] = true
+			b.save()
+			return true
+		}
+	}
+	return false
+}
+
+// save writes the used secrets; the caller holds the lock.
+func (b *bypass) save() {
+	if len(b.used.path) == 0 {
+		return
+	}
+
+	hashes := make([]string, 0, len(b.used.secrets))
+	for hash := range b.used.secrets {
+		hashes = append(hashes, hash)
+	}
+	data, err := json.Marshal(hashes)
+	if err != nil {
+		b.logger.Printf("%s: used bypass secrets encode error: %v", b.name, err)
+		return
+	}
+	if err := writeFileAtomic(b.used.path, "bypass-*.tmp", data); err != nil {
+		b.logger.Printf("%s: used bypass secrets %v", b.name, err)
+	}
+}
+
+// load restores the used secrets. A missing file is not an error.
+func (b *bypass) load() {
+	if len(b.used.path) == 0 {
+		return
+	}
+
+	data, err := os.ReadFile(b.used.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			b.logger.Printf("%s: failed to load used bypass secrets from %s: %v", b.name, b.used.path, err)
+		}
+		return
+	}
+
+	var hashes []string
+	if err := json.Unmarshal(data, &hashes); err != nil {
+		b.logger.Printf("%s: ignoring used bypass secrets %s: %v", b.name, b.used.path, err)
+		return
+	}
+	for _, hash := range hashes {
+		b.used.secrets[hash] = true
+	}
+}
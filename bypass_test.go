@@ -0,0 +1,237 @@
+package geoblock_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	geoblock "github.com/PascalMinder/geoblock"
+)
+
+const (
+	bypassSecret      = "0123456789abcdef0123456789abcdef"
+	bypassIssueSecret = "one-time-secret-1"
+)
+
+func createBypassHandler(t *testing.T) http.Handler {
+	t.Helper()
+
+	cfg := createTesterConfig()
+	cfg.Countries = append(cfg.Countries, "CH")
+	cfg.BypassSecret = bypassSecret
+	cfg.BypassIssuePath = "/bypass"
+	cfg.BypassIssueSecrets = []string{bypassIssueSecret}
+	return createCountryAPIHandler(t, cfg, exampleCountries, nil)
+}
+
+func bypassToken(secret string, expiry time.Time) string {
+	payload := strconv.FormatInt(expiry.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func bypassRequest(handler http.Handler, header, cookie string) *http.Response {
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.Header.Add(xForwardedFor, caExampleIP)
+	if len(header) != 0 {
+		req.Header.Set("X-GeoBlock-Bypass", header)
+	}
+	if len(cookie) != 0 {
+		req.AddCookie(&http.Cookie{Name: "geoblock_bypass", Value: cookie})
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder.Result()
+}
+
+func issueRequest(handler http.Handler, secret string) *http.Response {
+	form := url.Values{"secret": {secret}}
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/bypass", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add(xForwardedFor, caExampleIP)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder.Result()
+}
+
+func TestBypassTokenHeader(t *testing.T) {
+	handler := createBypassHandler(t)
+
+	assertStatusCode(t, bypassRequest(handler, "", ""), http.StatusForbidden)
+
+	token := bypassToken(bypassSecret, time.Now().Add(time.Hour))
+	assertStatusCode(t, bypassRequest(handler, token, ""), http.StatusOK)
+}
+
+func TestBypassTokenCookie(t *testing.T) {
+	handler := createBypassHandler(t)
+
+	token := bypassToken(bypassSecret, time.Now().Add(time.Hour))
+	assertStatusCode(t, bypassRequest(handler, "", token), http.StatusOK)
+}
+
+func TestBypassTokenIgnored(t *testing.T) {
+	handler := createBypassHandler(t)
+
+	for name, token := range map[string]string{
+		"expired":   bypassToken(bypassSecret, time.Now().Add(-time.Minute)),
+		"signature": bypassToken("fedcba9876543210fedcba9876543210", time.Now().Add(time.Hour)),
+		"unsigned":  strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10),
+		"garbage":   "not.a-token",
+	} {
+		if resp := bypassRequest(handler, token, ""); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s: expected status code %d, got %d", name, http.StatusForbidden, resp.StatusCode)
+		}
+	}
+}
+
+func TestBypassTokenNotForwarded(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.Countries = append(cfg.Countries, "CH")
+	cfg.BypassSecret = bypassSecret
+
+	forwarded := false
+	next := http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+		forwarded = true
+		if value := req.Header.Get("X-GeoBlock-Bypass"); len(value) != 0 {
+			t.Errorf("expected bypass header to be removed, got %q", value)
+		}
+		if value := req.Header.Get("Cookie"); value != "session=abc" {
+			t.Errorf("expected bypass cookie to be removed, got %q", value)
+		}
+	})
+	handler := createCountryAPIHandler(t, cfg, exampleCountries, next)
+
+	token := bypassToken(bypassSecret, time.Now().Add(time.Hour))
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.Header.Add(xForwardedFor, caExampleIP)
+	req.Header.Set("X-GeoBlock-Bypass", token)
+	req.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+	req.AddCookie(&http.Cookie{Name: "geoblock_bypass", Value: token})
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if !forwarded {
+		t.Fatal("expected request to be forwarded")
+	}
+}
+
+func TestBypassTokenKeepsDenyListsAndBans(t *testing.T) {
+	token := bypassToken(bypassSecret, time.Now().Add(time.Hour))
+
+	t.Run("denied IP", func(t *testing.T) {
+		cfg := createTesterConfig()
+		cfg.Countries = append(cfg.Countries, "CH")
+		cfg.DeniedIPAddresses = []string{caExampleIP}
+		cfg.BypassSecret = bypassSecret
+
+		handler := createCountryAPIHandler(t, cfg, exampleCountries, nil)
+		assertStatusCode(t, bypassRequest(handler, token, ""), http.StatusForbidden)
+	})
+
+	t.Run("ban", func(t *testing.T) {
+		cfg := createTesterConfig()
+		cfg.Countries = append(cfg.Countries, "CH")
+		cfg.BanThreshold = 1
+		cfg.BypassSecret = bypassSecret
+
+		handler := createCountryAPIHandler(t, cfg, exampleCountries, nil)
+		assertStatusCode(t, bypassRequest(handler, "", ""), http.StatusForbidden)
+		assertStatusCode(t, bypassRequest(handler, token, ""), http.StatusForbidden)
+	})
+}
+
+func TestBypassIssueCookie(t *testing.T) {
+	handler := createBypassHandler(t)
+
+	resp := issueRequest(handler, bypassIssueSecret)
+	assertStatusCode(t, resp, http.StatusSeeOther)
+
+	cookies := resp.Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "geoblock_bypass" {
+		t.Fatalf("expected bypass cookie, got %v", cookies)
+	}
+	if !cookies[0].HttpOnly || !cookies[0].Secure {
+		t.Errorf("expected HttpOnly and Secure cookie, got %v", cookies[0])
+	}
+	assertStatusCode(t, bypassRequest(handler, "", cookies[0].Value), http.StatusOK)
+
+	// the secret can be used once only
+	resp = issueRequest(handler, bypassIssueSecret)
+	assertStatusCode(t, resp, http.StatusForbidden)
+	if len(resp.Cookies()) != 0 {
+		t.Errorf("expected no cookie, got %v", resp.Cookies())
+	}
+}
+
+func TestBypassUsedSecretsSaved(t *testing.T) {
+	dir := t.TempDir()
+
+	cfg := createTesterConfig()
+	cfg.Countries = append(cfg.Countries, "CH")
+	cfg.IPDatabaseCachePath = filepath.Join(dir, "ip-cache.db")
+	cfg.BypassSecret = bypassSecret
+	cfg.BypassIssuePath = "/bypass"
+	cfg.BypassIssueSecrets = []string{bypassIssueSecret}
+
+	handler := createCountryAPIHandler(t, cfg, exampleCountries, nil)
+	assertStatusCode(t, issueRequest(handler, bypassIssueSecret), http.StatusSeeOther)
+
+	paths, err := filepath.Glob(filepath.Join(dir, "geoblock-bypass-*.json"))
+	if err != nil || len(paths) != 1 {
+		t.Fatalf("expected one file of used secrets, got %v (%v)", paths, err)
+	}
+	data, err := os.ReadFile(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), bypassIssueSecret) {
+		t.Fatal("expected the used secret to be stored hashed")
+	}
+}
+
+func TestBypassIssueRejectsRequests(t *testing.T) {
+	handler := createBypassHandler(t)
+
+	assertStatusCode(t, issueRequest(handler, "wrong-one-time-secret"), http.StatusForbidden)
+	assertStatusCode(t, issueRequest(handler, ""), http.StatusForbidden)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/bypass?secret="+bypassIssueSecret, nil)
+	req.Header.Add(xForwardedFor, caExampleIP)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	assertStatusCode(t, recorder.Result(), http.StatusMethodNotAllowed)
+}
+
+func TestInvalidBypassConfig(t *testing.T) {
+	for name, modify := range map[string]func(cfg *geoblock.Config){
+		"short secret":         func(cfg *geoblock.Config) { cfg.BypassSecret = "secret" },
+		"issue without secret": func(cfg *geoblock.Config) { cfg.BypassSecret = "" },
+		"issue path":           func(cfg *geoblock.Config) { cfg.BypassIssuePath = "bypass" },
+		"no issue secrets":     func(cfg *geoblock.Config) { cfg.BypassIssueSecrets = nil },
+		"short issue secret":   func(cfg *geoblock.Config) { cfg.BypassIssueSecrets = []string{"short"} },
+		"negative ttl":         func(cfg *geoblock.Config) { cfg.BypassTokenTTLSeconds = -1 },
+	} {
+		cfg := createTesterConfig()
+		cfg.Countries = append(cfg.Countries, "CH")
+		cfg.BypassSecret = bypassSecret
+		cfg.BypassIssuePath = "/bypass"
+		cfg.BypassIssueSecrets = []string{bypassIssueSecret}
+		modify(cfg)
+
+		if _, err := newCountryAPIHandler(t, cfg, nil, nil); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
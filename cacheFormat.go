@@ -0,0 +1,278 @@
+package geoblock
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	lru "github.com/PascalMinder/geoblock/lrucache"
+)
+
+// Persistence formats for the IP cache snapshot.
+const (
+	cacheFormatGob   = "gob"   // binary, with versioned checksum header
+	cacheFormatJSONL = "jsonl" // one JSON object per line
+	cacheFormatCSV   = "csv"   // ip,country,timestamp[,pinned[,asn[,subdivision]]]
+)
+
+// csvHeader lists the CSV columns; the trailing pinned, asn and subdivision
+// columns are optional on import so three-column files written by hand keep
+// working.
+var csvHeader = []string{"ip", "country", "timestamp", "pinned", "asn", "subdivision"}
+
+// csvRequiredFields is the number of mandatory CSV columns.
+const csvRequiredFields = 3
+
+// gzipMagic are the first two bytes of every gzip stream (RFC 1952).
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// cacheRow is the human-readable representation of one cache entry.
+type cacheRow struct {
+	IP          string    `json:"ip"`
+	Country     string    `json:"country"`
+	Timestamp   time.Time `json:"timestamp"`
+	Pinned      bool      `json:"pinned,omitempty"`
+	ASN         uint32    `json:"asn,omitempty"`
+	Subdivision string    `json:"subdivision,omitempty"`
+}
+
+// resolveCacheFormat returns the configured format, or derives it from the
+// file extension if none is configured.
+func resolveCacheFormat(path, configured string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(configured)) {
+	case cacheFormatGob:
+		return cacheFormatGob, nil
+	case cacheFormatJSONL:
+		return cacheFormatJSONL, nil
+	case cacheFormatCSV:
+		return cacheFormatCSV, nil
+	case "":
+	default:
+		return "", fmt.Errorf("unknown cache format [%s]", configured)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jsonl", ".ndjson":
+		return cacheFormatJSONL, nil
+	case ".csv":
+		return cacheFormatCSV, nil
+	default:
+		return cacheFormatGob, nil
+	}
+}
+
+// encodeCache writes the cache contents (MRU -> LRU) in the given format,
+// optionally wrapped in a gzip stream.
+func encodeCache(w io.Writer, cache *lru.LRUCache, format string, compress bool) error {
+	if !compress {
+		return encodeCacheFormat(w, cache, format)
+	}
+
+	zw := gzip.NewWriter(w)
+	if err := encodeCacheFormat(zw, cache, format); err != nil {
+		_ = zw.Close()
+		return err
+	}
+	return zw.Close()
+}
+
+func encodeCacheFormat(w io.Writer, cache *lru.LRUCache, format string) error {
+	switch format {
+	case cacheFormatJSONL:
+		return encodeCacheJSONL(w, cacheRows(cache))
+	case cacheFormatCSV:
+		return encodeCacheCSV(w, cacheRows(cache))
+	default:
+		var payload bytes.Buffer
+		if err := cache.Export(&payload); err != nil {
+			return err
+		}
+		return encodeSnapshot(w, payload.Bytes())
+	}
+}
+
+// decodeCache parses the snapshot in data into cache entries (MRU -> LRU).
+// Rows of the text formats that fail validation are skipped and counted in
+// invalid. Gzip compressed snapshots are detected by their magic bytes, so
+// compression can be switched on or off without losing the existing file.
+func decodeCache(data []byte, format string) (entries []lru.Pair, invalid int, err error) {
+	if bytes.HasPrefix(data, gzipMagic) {
+		if data, err = gunzip(data); err != nil {
+			return nil, 0, fmt.Errorf("decompress snapshot: %w", err)
+		}
+	}
+
+	var rows []cacheRow
+	switch format {
+	case cacheFormatJSONL:
+		rows, err = decodeCacheJSONL(bytes.NewReader(data))
+	case cacheFormatCSV:
+		rows, err = decodeCacheCSV(bytes.NewReader(data))
+	default:
+		var payload []byte
+		if payload, err = decodeSnapshot(data); err != nil {
+			return nil, 0, err
+		}
+		_, entries, err = lru.DecodeSnapshot(bytes.NewReader(payload))
+		return entries, 0, err
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	entries = make([]lru.Pair, 0, len(rows))
+	for _, row := range rows {
+		if row.normalize() != nil {
+			invalid++
+			continue
+		}
+		entry := ipEntry{
+			Country: row.Country, ASN: row.ASN, Subdivision: row.Subdivision, Timestamp: row.Timestamp, Pinned: row.Pinned,
+		}
+		entries = append(entries, lru.Pair{Key: row.IP, Value: entry})
+	}
+	return entries, invalid, nil
+}
+
+func gunzip(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+func cacheRows(cache *lru.LRUCache) []cacheRow {
+	_, pairs := cache.Snapshot()
+
+	rows := make([]cacheRow, 0, len(pairs))
+	for _, p := range pairs {
+		ip, ok := p.Key.(string)
+		entry, isEntry := p.Value.(ipEntry)
+		if !ok || !isEntry {
+			continue
+		}
+		rows = append(rows, cacheRow{
+			IP: ip, Country: entry.Country, Timestamp: entry.Timestamp, Pinned: entry.Pinned,
+			ASN: entry.ASN, Subdivision: entry.Subdivision,
+		})
+	}
+	return rows
+}
+
+func encodeCacheJSONL(w io.Writer, rows []cacheRow) error {
+	enc := json.NewEncoder(w)
+	for _, row := range rows {
+		if err := enc.Encode(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeCacheJSONL(r io.Reader) ([]cacheRow, error) {
+	var rows []cacheRow
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var row cacheRow
+		if err := json.Unmarshal([]byte(text), &row); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, scanner.Err()
+}
+
+func encodeCacheCSV(w io.Writer, rows []cacheRow) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		record := []string{
+			row.IP, row.Country, row.Timestamp.Format(time.RFC3339Nano),
+			strconv.FormatBool(row.Pinned), strconv.FormatUint(uint64(row.ASN), 10), row.Subdivision,
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func decodeCacheCSV(r io.Reader) ([]cacheRow, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	var rows []cacheRow
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < csvRequiredFields || len(record) > len(csvHeader) {
+			return nil, fmt.Errorf("line %d: expected %d to %d fields, got %d",
+				line, csvRequiredFields, len(csvHeader), len(record))
+		}
+		if line == 1 && strings.EqualFold(record[0], csvHeader[0]) {
+			continue
+		}
+
+		// An unparsable timestamp leaves the zero time, which normalize rejects.
+		timestamp, _ := time.Parse(time.RFC3339Nano, record[2])
+		row := cacheRow{IP: record[0], Country: record[1], Timestamp: timestamp}
+		if len(record) > 3 {
+			row.Pinned, _ = strconv.ParseBool(record[3])
+		}
+		if len(record) > 4 {
+			asn, _ := strconv.ParseUint(record[4], 10, 32)
+			row.ASN = uint32(asn)
+		}
+		if len(record) > 5 {
+			row.Subdivision = record[5]
+		}
+		rows = append(rows, row)
+	}
+}
+
+// normalize validates the row and rewrites the IP in the canonical form used
+// as cache key, so hand-written rows (e.g. upper-case IPv6) still match.
+func (r *cacheRow) normalize() error {
+	ip, err := parseIP(r.IP)
+	if err != nil {
+		return err
+	}
+	if len([]rune(r.Country)) != countryCodeLength {
+		return fmt.Errorf("invalid country code [%s] for [%s]", r.Country, r.IP)
+	}
+	if len(r.Subdivision) != 0 && !isSubdivisionCode(r.Subdivision) {
+		return fmt.Errorf("invalid subdivision code [%s] for [%s]", r.Subdivision, r.IP)
+	}
+	if r.Timestamp.IsZero() {
+		return fmt.Errorf("missing timestamp for [%s]", r.IP)
+	}
+	r.IP = ip.String()
+	return nil
+}
@@ -0,0 +1,60 @@
+package geoblock
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	lru "github.com/PascalMinder/geoblock/lrucache"
+)
+
+// Compare snapshot size and encode time per format, with and without gzip:
+//
+//	go test -run '^$' -bench BenchmarkCacheSnapshot -benchmem
+func BenchmarkCacheSnapshot(b *testing.B) {
+	gob.Register(ipEntry{})
+
+	for _, entries := range []int{10_000, 100_000, 1_000_000} {
+		cache := benchmarkCache(b, entries)
+
+		for _, format := range []string{cacheFormatGob, cacheFormatJSONL, cacheFormatCSV} {
+			for _, compress := range []bool{false, true} {
+				name := fmt.Sprintf("entries=%d/format=%s/gzip=%t", entries, format, compress)
+				b.Run(name, func(b *testing.B) {
+					var buf bytes.Buffer
+					for i := 0; i < b.N; i++ {
+						buf.Reset()
+						if err := encodeCache(&buf, cache, format, compress); err != nil {
+							b.Fatal(err)
+						}
+					}
+					b.ReportMetric(float64(buf.Len()), "bytes/snapshot")
+				})
+			}
+		}
+	}
+}
+
+func benchmarkCache(b *testing.B, entries int) *lru.LRUCache {
+	b.Helper()
+
+	cache, err := lru.NewLRUCache(entries)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	countries := []string{"CH", "DE", "US", "CA", "FR", "AA"}
+	now := time.Now()
+	ip := make(net.IP, net.IPv4len)
+	for i := 0; i < entries; i++ {
+		ip[0], ip[1], ip[2], ip[3] = byte(i>>24)|1, byte(i>>16), byte(i>>8), byte(i)
+		cache.Add(ip.String(), ipEntry{
+			Country:   countries[i%len(countries)],
+			Timestamp: now.Add(-time.Duration(i) * time.Second),
+		})
+	}
+	return cache
+}
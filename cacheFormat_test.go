@@ -0,0 +1,230 @@
+package geoblock_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	geoblock "github.com/PascalMinder/geoblock"
+)
+
+func TestCacheFormatJSONLIsWritten(t *testing.T) {
+	server := httptest.NewServer(&CountryCodeHandler{ResponseCountryCode: "CH"})
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "ip-cache.jsonl")
+
+	cfg := createTesterConfig()
+	cfg.Countries = append(cfg.Countries, "CH")
+	cfg.API = server.URL + "/{ip}"
+	cfg.IPDatabaseCachePath = path
+
+	ctx := context.Background()
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+
+	handler, err := geoblock.New(ctx, next, cfg, t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.Header.Add(xForwardedFor, chExampleIP)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	assertStatusCode(t, recorder.Result(), http.StatusOK)
+
+	waitForFileNonEmpty(t, path, 2*time.Second)
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open snapshot failed: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		t.Fatal("expected at least one JSON line")
+	}
+
+	var row struct {
+		IP        string    `json:"ip"`
+		Country   string    `json:"country"`
+		Timestamp time.Time `json:"timestamp"`
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+		t.Fatalf("snapshot line is not JSON: %v (%q)", err, scanner.Text())
+	}
+	if row.IP != chExampleIP || row.Country != "CH" || row.Timestamp.IsZero() {
+		t.Fatalf("unexpected row: %+v", row)
+	}
+}
+
+func TestCacheFormatCSVSeedsCache(t *testing.T) {
+	var apiCalls int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&apiCalls, 1)
+		_, _ = rw.Write([]byte("CA"))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "seed.csv")
+	seed := strings.Join([]string{
+		"ip,country,timestamp",
+		chExampleIP + ",CH," + time.Now().UTC().Format(time.RFC3339),
+		"2001:DB8::1,CH," + time.Now().UTC().Format(time.RFC3339),
+	}, "\n")
+	if err := os.WriteFile(path, []byte(seed), 0o600); err != nil {
+		t.Fatalf("seed csv failed: %v", err)
+	}
+
+	cfg := createTesterConfig()
+	cfg.Countries = append(cfg.Countries, "CH")
+	cfg.API = server.URL + "/{ip}"
+	cfg.IPDatabaseCachePath = path
+
+	ctx := context.Background()
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+
+	handler, err := geoblock.New(ctx, next, cfg, t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ip := range []string{chExampleIP, "2001:db8::1"} {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.Header.Add(xForwardedFor, ip)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		assertStatusCode(t, recorder.Result(), http.StatusOK)
+	}
+
+	if got := atomic.LoadInt32(&apiCalls); got != 0 {
+		t.Fatalf("expected seeded entries to be served from cache, got %d API calls", got)
+	}
+}
+
+func TestCacheFormatUnknownIsRejected(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.Countries = append(cfg.Countries, "CH")
+	cfg.IPDatabaseCachePath = filepath.Join(t.TempDir(), "ip-cache.db")
+	cfg.IPDatabaseCacheFormat = "xml"
+
+	ctx := context.Background()
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+
+	_, err := geoblock.New(ctx, next, cfg, t.Name())
+	if err == nil {
+		t.Fatal("expected error for unknown cache format")
+	}
+}
+
+func TestWarmLoadSkipsExpiredAndInvalidEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seed.csv")
+	now := time.Now().UTC()
+	seed := strings.Join([]string{
+		"ip,country,timestamp",
+		"192.0.2.1,CH," + now.Add(-time.Minute).Format(time.RFC3339),
+		"192.0.2.2,CH," + now.Add(-48*time.Hour).Format(time.RFC3339), // expired
+		"not-an-ip,CH," + now.Format(time.RFC3339),                    // invalid
+		"192.0.2.3,CHE," + now.Format(time.RFC3339),                   // invalid
+		"192.0.2.4,CH,yesterday",                                      // invalid
+	}, "\n")
+	if err := os.WriteFile(path, []byte(seed), 0o600); err != nil {
+		t.Fatalf("seed csv failed: %v", err)
+	}
+
+	var logBuf bytes.Buffer
+	opt := snapshotTestOptions(t, path, &logBuf)
+	opt.CacheTTL = 24 * time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cache, _, err := geoblock.InitializeCache(ctx, opt)
+	if err != nil {
+		t.Fatalf("InitializeCache failed: %v", err)
+	}
+
+	if got := cache.Keys(); len(got) != 1 || got[0] != "192.0.2.1" {
+		t.Fatalf("expected only the fresh entry to be loaded, got %v", got)
+	}
+	want := "warm-loaded 1 IP cache entries from " + path + " (skipped: 1 expired, 3 invalid, 0 over limit, 0 over cache size)"
+	if !strings.Contains(logBuf.String(), want) {
+		t.Fatalf("expected %q in log, got:\n%s", want, logBuf.String())
+	}
+}
+
+func TestWarmLoadLimitKeepsMostRecentEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seed.jsonl")
+	timestamp := time.Now().UTC().Format(time.RFC3339)
+	var seed strings.Builder
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} { // MRU -> LRU
+		seed.WriteString(`{"ip":"` + ip + `","country":"CH","timestamp":"` + timestamp + `"}` + "\n")
+	}
+	if err := os.WriteFile(path, []byte(seed.String()), 0o600); err != nil {
+		t.Fatalf("seed jsonl failed: %v", err)
+	}
+
+	var logBuf bytes.Buffer
+	opt := snapshotTestOptions(t, path, &logBuf)
+	opt.WarmLoadLimit = 2
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cache, _, err := geoblock.InitializeCache(ctx, opt)
+	if err != nil {
+		t.Fatalf("InitializeCache failed: %v", err)
+	}
+
+	want := []interface{}{"192.0.2.1", "192.0.2.2"}
+	if got := cache.Keys(); !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected keys after limited warm-load: want=%v got=%v", want, got)
+	}
+	if !strings.Contains(logBuf.String(), "1 over limit") {
+		t.Fatalf("expected over limit count in log, got:\n%s", logBuf.String())
+	}
+}
+
+func TestWarmLoadStopsAtCacheSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seed.jsonl")
+	timestamp := time.Now().UTC().Format(time.RFC3339)
+	var seed strings.Builder
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4"} { // MRU -> LRU
+		seed.WriteString(`{"ip":"` + ip + `","country":"CH","timestamp":"` + timestamp + `"}` + "\n")
+	}
+	if err := os.WriteFile(path, []byte(seed.String()), 0o600); err != nil {
+		t.Fatalf("seed jsonl failed: %v", err)
+	}
+
+	var logBuf bytes.Buffer
+	opt := snapshotTestOptions(t, path, &logBuf)
+	opt.CacheSize = 2
+	opt.WarmLoadLimit = 3
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cache, _, err := geoblock.InitializeCache(ctx, opt)
+	if err != nil {
+		t.Fatalf("InitializeCache failed: %v", err)
+	}
+
+	want := []interface{}{"192.0.2.1", "192.0.2.2"}
+	if got := cache.Keys(); !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected keys after warm-load: want=%v got=%v", want, got)
+	}
+	if !strings.Contains(logBuf.String(), "0 over limit, 2 over cache size") {
+		t.Fatalf("expected over cache size count in log, got:\n%s", logBuf.String())
+	}
+}
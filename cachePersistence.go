@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"path/filepath"
 	"sync"
@@ -23,6 +24,10 @@ const (
 type Options struct {
 	CacheSize       int
 	CachePath       string        // file path for persisted cache; if empty or invalid > feature OFF
+	CacheFormat     string        // gob, jsonl or csv; empty > derived from the CachePath extension
+	CacheCompress   bool          // gzip snapshots on write; compressed files are detected on read
+	CacheTTL        time.Duration // entries older than this are skipped on warm-load; 0 > never expire
+	WarmLoadLimit   int           // max entries (most recent first) to warm-load; 0 > no limit
 	PersistInterval time.Duration // base interval; used for debounce + max interval
 	Logger          *log.Logger
 	SilentStartUp   bool
@@ -31,10 +36,12 @@ type Options struct {
 
 // CachePersist manages debounced, low-CPU persistence of the LRU cache.
 type CachePersist struct {
-	path  string
-	cache *lru.LRUCache
-	log   *log.Logger
-	name  string
+	path     string
+	format   string
+	compress bool
+	cache    *lru.LRUCache
+	log      *log.Logger
+	name     string
 
 	ch   chan struct{} // edge-trigger signal
 	quit chan struct{} // stop signal
@@ -49,13 +56,16 @@ type CachePersist struct {
 // NewCachePersist constructs a new persistence controller.
 // It does NOT start the worker; caller must call go p.Run(ctx).
 func NewCachePersist(
-	path string, cache *lru.LRUCache, logger *log.Logger, name string, persistInterval time.Duration) *CachePersist {
+	path, format string, compress bool, cache *lru.LRUCache, logger *log.Logger, name string, persistInterval time.Duration,
+) *CachePersist {
 	if persistInterval <= 0 {
 		persistInterval = DefaultPersistInterval
 	}
 
 	p := &CachePersist{
 		path:        path,
+		format:      format,
+		compress:    compress,
 		cache:       cache,
 		log:         logger,
 		name:        name,
@@ -159,44 +169,50 @@ func (p *CachePersist) flushIfDirty() {
 	}
 
 	var buf bytes.Buffer
-	if err := p.cache.Export(&buf); err != nil {
+	if err := encodeCache(&buf, p.cache, p.format, p.compress); err != nil {
 		p.log.Printf("%s: cache snapshot encode error: %v", p.name, err)
 		return
 	}
 
-	dir := filepath.Dir(p.path)
-	tmp, err := os.CreateTemp(dir, "ipdb-*.tmp")
-	if err != nil {
-		p.log.Printf("%s: snapshot temp file error: %v", p.name, err)
+	if err := writeFileAtomic(p.path, "ipdb-*.tmp", buf.Bytes()); err != nil {
+		p.log.Printf("%s: snapshot %v", p.name, err)
 		return
 	}
+
+	atomic.StoreUint32(&p.cacheDirty, 0)
+	p.lastFlush.Store(time.Now().UnixNano())
+}
+
+// writeFileAtomic writes data to a temp file in the target folder, fsyncs it
+// and renames it over path, so readers never observe a partial file.
+func writeFileAtomic(path, tmpPattern string, data []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, tmpPattern)
+	if err != nil {
+		return fmt.Errorf("temp file error: %w", err)
+	}
 	tmpPath := tmp.Name()
 
-	if _, err := tmp.Write(buf.Bytes()); err != nil {
+	if _, err := tmp.Write(data); err != nil {
 		tmp.Close()
 		_ = os.Remove(tmpPath)
-		p.log.Printf("%s: snapshot write error: %v", p.name, err)
-		return
+		return fmt.Errorf("write error: %w", err)
 	}
 	if err := tmp.Sync(); err != nil {
 		tmp.Close()
 		_ = os.Remove(tmpPath)
-		p.log.Printf("%s: snapshot fsync error: %v", p.name, err)
-		return
+		return fmt.Errorf("fsync error: %w", err)
 	}
 	if err := tmp.Close(); err != nil {
 		_ = os.Remove(tmpPath)
-		p.log.Printf("%s: snapshot close error: %v", p.name, err)
-		return
+		return fmt.Errorf("close error: %w", err)
 	}
-	if err := os.Rename(tmpPath, p.path); err != nil {
+	if err := os.Rename(tmpPath, path); err != nil {
 		_ = os.Remove(tmpPath)
-		p.log.Printf("%s: snapshot rename error: %v", p.name, err)
-		return
+		return fmt.Errorf("rename error: %w", err)
 	}
 
-	atomic.StoreUint32(&p.cacheDirty, 0)
-	p.lastFlush.Store(time.Now().UnixNano())
+	return nil
 }
 
 type sharedCacheEntry struct {
@@ -220,6 +236,7 @@ func GetOrInitCache(opt Options) (*lru.LRUCache, *CachePersist, error) {
 	defer sharedCachesMu.Unlock()
 
 	if sc, ok := sharedCaches[opt.Name]; ok {
+		resizeSharedCache(sc, opt)
 		return sc.cache, sc.persist, nil
 	}
 
@@ -232,12 +249,33 @@ func GetOrInitCache(opt Options) (*lru.LRUCache, *CachePersist, error) {
 	return cache, persist, nil
 }
 
+// resizeSharedCache applies a changed cacheSize from a config reload to an
+// already warm shared cache instead of discarding it.
+func resizeSharedCache(sc *sharedCacheEntry, opt Options) {
+	if opt.CacheSize <= 1 || opt.CacheSize == sc.cache.Size() {
+		return
+	}
+
+	previous := sc.cache.Size()
+	evicted, err := sc.cache.Resize(opt.CacheSize)
+	if err != nil || opt.Logger == nil {
+		return
+	}
+	opt.Logger.Printf("%s: IP cache resized from %d to %d entries (%d evicted)",
+		opt.Name, previous, opt.CacheSize, evicted)
+}
+
 func InitializeCache(ctx context.Context, opt Options) (*lru.LRUCache, *CachePersist, error) {
 	if opt.CacheSize <= 1 {
 		return nil, nil, fmt.Errorf("cache size must be bigger than 1")
 	}
 
-	cache, err := lru.NewLRUCache(opt.CacheSize)
+	// persist is assigned below once the path is validated; evictions before
+	// that (or with persistence off) hit the nil-safe MarkDirty.
+	var persist *CachePersist
+	cache, err := lru.NewLRUCache(opt.CacheSize, lru.OnEvict(func(_, _ interface{}) {
+		persist.MarkDirty()
+	}))
 	if err != nil {
 		return nil, nil, fmt.Errorf("create lru cache: %w", err)
 	}
@@ -247,27 +285,121 @@ func InitializeCache(ctx context.Context, opt Options) (*lru.LRUCache, *CachePer
 		logger = log.New(os.Stdout, "", log.LstdFlags)
 	}
 
-	var persist *CachePersist
+	if opt.CachePath == "" {
+		logger.Printf("%s: IP cache persistence disabled (no path configured)", opt.Name)
+		return cache, nil, nil
+	}
 
-	if opt.CachePath != "" {
-		path, err := ValidatePersistencePath(opt.CachePath)
-		if err != nil {
-			logger.Printf("%s: IP cache persistence disabled (path invalid): %v", opt.Name, err)
-		} else {
-			// Try warm load; ignore file-not-exist
-			if err := cache.ImportFromFile(path); err != nil && !os.IsNotExist(err) {
-				logger.Printf("%s: failed to warm-load IP cache from %s: %v", opt.Name, path, err)
-			}
+	path, err := ValidatePersistencePath(opt.CachePath)
+	if err != nil {
+		logger.Printf("%s: IP cache persistence disabled (path invalid): %v", opt.Name, err)
+		return cache, nil, nil
+	}
 
-			persist = NewCachePersist(path, cache, logger, opt.Name, opt.PersistInterval)
-			go persist.Run(ctx)
-			if !opt.SilentStartUp {
-				logger.Printf("%s: IP cache persistence enabled -> %s", opt.Name, path)
-			}
+	format, err := resolveCacheFormat(path, opt.CacheFormat)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	warmLoadCache(cache, path, format, opt, logger)
+
+	persist = NewCachePersist(path, format, opt.CacheCompress, cache, logger, opt.Name, opt.PersistInterval)
+	go persist.Run(ctx)
+	if !opt.SilentStartUp {
+		logger.Printf("%s: IP cache persistence enabled -> %s (%s)", opt.Name, path, format)
+	}
+
+	return cache, persist, nil
+}
+
+// warmLoadStats summarizes what a warm-load did with the persisted entries.
+type warmLoadStats struct {
+	loaded    int
+	expired   int
+	invalid   int
+	overLimit int
+	overSize  int // did not fit into the cache
+}
+
+// warmLoadCache restores the cache from a snapshot at path. A missing file is
+// not an error; a corrupt or unreadable one is quarantined so the next flush
+// starts from a clean file.
+func warmLoadCache(cache *lru.LRUCache, path, format string, opt Options, logger *log.Logger) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Printf("%s: failed to warm-load IP cache from %s: %v", opt.Name, path, err)
+		}
+		return
+	}
+
+	entries, invalid, err := decodeCache(data, format)
+	if err == nil {
+		stats := loadWarmEntries(cache, entries, opt.CacheTTL, opt.WarmLoadLimit, time.Now())
+		stats.invalid += invalid
+		if !opt.SilentStartUp || stats.expired+stats.invalid > 0 {
+			logger.Printf("%s: warm-loaded %d IP cache entries from %s "+
+				"(skipped: %d expired, %d invalid, %d over limit, %d over cache size)",
+				opt.Name, stats.loaded, path, stats.expired, stats.invalid, stats.overLimit, stats.overSize)
 		}
+		return
+	}
+
+	logger.Printf("%s: failed to warm-load IP cache from %s: %v", opt.Name, path, err)
+	if target, qerr := quarantineSnapshot(path); qerr != nil {
+		logger.Printf("%s: failed to quarantine IP cache snapshot %s: %v", opt.Name, path, qerr)
 	} else {
-		logger.Printf("%s: IP cache persistence disabled (no path configured)", opt.Name)
+		logger.Printf("%s: corrupt IP cache snapshot moved to %s", opt.Name, target)
 	}
+}
 
-	return cache, persist, nil
+// loadWarmEntries replaces the cache contents with the persisted entries
+// (MRU -> LRU), dropping invalid and expired ones. Only the most recently used
+// entries up to the limit, if any, and the configured cache size are kept; the
+// configured cache size always wins over the size stored in the snapshot.
+func loadWarmEntries(cache *lru.LRUCache, entries []lru.Pair, ttl time.Duration, limit int, now time.Time) warmLoadStats {
+	var stats warmLoadStats
+	accepted := make([]lru.Pair, 0, len(entries))
+
+	for _, e := range entries {
+		switch {
+		case !isValidCacheEntry(e):
+			stats.invalid++
+		case isExpiredCacheEntry(e, ttl, now):
+			stats.expired++
+		case limit > 0 && limit <= cache.Size() && len(accepted) >= limit:
+			stats.overLimit++
+		case len(accepted) >= cache.Size():
+			stats.overSize++
+		default:
+			accepted = append(accepted, e)
+		}
+	}
+
+	cache.Purge()
+	// Add the oldest first so the MRU ends up in front.
+	for i := len(accepted) - 1; i >= 0; i-- {
+		cache.Add(accepted[i].Key, accepted[i].Value)
+	}
+	stats.loaded = cache.Length()
+
+	return stats
+}
+
+// isValidCacheEntry checks that the key is an IP address and, for IP lookup
+// entries, that the country code has the expected shape.
+func isValidCacheEntry(e lru.Pair) bool {
+	key, ok := e.Key.(string)
+	if !ok || net.ParseIP(key) == nil {
+		return false
+	}
+	if entry, ok := e.Value.(ipEntry); ok {
+		return len([]rune(entry.Country)) == countryCodeLength
+	}
+	return true
+}
+
+func isExpiredCacheEntry(e lru.Pair, ttl time.Duration, now time.Time) bool {
+	entry, ok := e.Value.(ipEntry)
+	return ok && !entry.Pinned && ttl > 0 && now.Sub(entry.Timestamp) >= ttl
 }
@@ -0,0 +1,120 @@
+package geoblock
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Snapshot file layout (all integers big endian):
+//
+//	magic    [8]byte  "GEOBLKDB"
+//	version  uint16   snapshot format version
+//	length   uint64   payload length in bytes
+//	checksum [32]byte SHA-256 of the payload
+//	payload  []byte   LRU cache export (gob)
+//
+// Files written before the header existed are a bare gob payload and are
+// treated as version 0.
+const (
+	snapshotMagic          = "GEOBLKDB"
+	snapshotVersionLegacy  = 0
+	snapshotVersionCurrent = 1
+	snapshotHeaderSize     = len(snapshotMagic) + 2 + 8 + sha256.Size
+)
+
+var (
+	errSnapshotTruncated = errors.New("snapshot truncated")
+	errSnapshotChecksum  = errors.New("snapshot checksum mismatch")
+)
+
+// snapshotMigration upgrades a payload from version v to v+1.
+type snapshotMigration func(payload []byte) ([]byte, error)
+
+// snapshotMigrations holds one migration per outdated format version. When
+// the payload shape changes (e.g. a new ipEntry layout gob can't decode),
+// bump snapshotVersionCurrent and register the upgrade from the old version.
+var snapshotMigrations = map[uint16]snapshotMigration{
+	// v0 -> v1 only added the header; the gob payload is unchanged.
+	snapshotVersionLegacy: func(payload []byte) ([]byte, error) { return payload, nil },
+}
+
+// encodeSnapshot writes the header for payload followed by the payload itself.
+func encodeSnapshot(w io.Writer, payload []byte) error {
+	header := make([]byte, 0, snapshotHeaderSize)
+	header = append(header, snapshotMagic...)
+	header = binary.BigEndian.AppendUint16(header, snapshotVersionCurrent)
+	header = binary.BigEndian.AppendUint64(header, uint64(len(payload)))
+	checksum := sha256.Sum256(payload)
+	header = append(header, checksum[:]...)
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// decodeSnapshot validates a snapshot and returns its payload migrated to
+// snapshotVersionCurrent.
+func decodeSnapshot(data []byte) ([]byte, error) {
+	version, payload, err := splitSnapshot(data)
+	if err != nil {
+		return nil, err
+	}
+
+	for version < snapshotVersionCurrent {
+		migrate, ok := snapshotMigrations[version]
+		if !ok {
+			return nil, fmt.Errorf("no migration for snapshot version %d", version)
+		}
+		if payload, err = migrate(payload); err != nil {
+			return nil, fmt.Errorf("migrate snapshot version %d: %w", version, err)
+		}
+		version++
+	}
+
+	return payload, nil
+}
+
+func splitSnapshot(data []byte) (uint16, []byte, error) {
+	if !bytes.HasPrefix(data, []byte(snapshotMagic)) {
+		return snapshotVersionLegacy, data, nil
+	}
+	if len(data) < snapshotHeaderSize {
+		return 0, nil, errSnapshotTruncated
+	}
+
+	rest := data[len(snapshotMagic):]
+	version := binary.BigEndian.Uint16(rest)
+	length := binary.BigEndian.Uint64(rest[2:])
+	checksum := rest[10 : 10+sha256.Size]
+	payload := data[snapshotHeaderSize:]
+
+	if version > snapshotVersionCurrent {
+		return 0, nil, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+	if uint64(len(payload)) != length {
+		return 0, nil, errSnapshotTruncated
+	}
+	if sum := sha256.Sum256(payload); !bytes.Equal(sum[:], checksum) {
+		return 0, nil, errSnapshotChecksum
+	}
+
+	return version, payload, nil
+}
+
+// quarantineSnapshot moves an unreadable snapshot out of the way so it is
+// kept for inspection but not loaded (or overwritten) again.
+func quarantineSnapshot(path string) (string, error) {
+	target := fmt.Sprintf("%s.corrupt-%s", path, time.Now().UTC().Format("20060102T150405Z"))
+	if err := os.Rename(path, target); err != nil {
+		return "", err
+	}
+	return target, nil
+}
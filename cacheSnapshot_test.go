@@ -0,0 +1,184 @@
+package geoblock_test
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	geoblock "github.com/PascalMinder/geoblock"
+	lru "github.com/PascalMinder/geoblock/lrucache"
+)
+
+func snapshotTestOptions(t *testing.T, path string, logBuf *bytes.Buffer) geoblock.Options {
+	t.Helper()
+
+	return geoblock.Options{
+		Name:            t.Name(),
+		CacheSize:       8,
+		CachePath:       path,
+		PersistInterval: 10 * time.Millisecond,
+		Logger:          log.New(logBuf, "", 0),
+	}
+}
+
+func writeSnapshotForTest(t *testing.T, path string) {
+	t.Helper()
+
+	var logBuf bytes.Buffer
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cache, persist, err := geoblock.InitializeCache(ctx, snapshotTestOptions(t, path, &logBuf))
+	if err != nil {
+		t.Fatalf("InitializeCache failed: %v", err)
+	}
+	cache.Add("1.2.3.4", true)
+	persist.MarkDirty()
+	waitForFileNonEmpty(t, path, 2*time.Second)
+}
+
+func findQuarantinedSnapshot(t *testing.T, path string) string {
+	t.Helper()
+
+	matches, err := filepath.Glob(path + ".corrupt-*")
+	if err != nil {
+		t.Fatalf("glob failed: %v", err)
+	}
+	if len(matches) != 1 {
+		t.Fatalf("expected exactly one quarantined snapshot, got %v", matches)
+	}
+	return matches[0]
+}
+
+func TestSnapshotHasVersionedHeader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip-cache.db")
+	writeSnapshotForTest(t, path)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read snapshot failed: %v", err)
+	}
+	if !bytes.HasPrefix(data, []byte("GEOBLKDB")) {
+		t.Fatalf("expected snapshot to start with the magic header, got %q", data[:8])
+	}
+}
+
+func TestSnapshotLegacyFileIsMigrated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip-cache.db")
+
+	// A snapshot written before the header existed is a bare LRU export.
+	legacy, _ := lru.NewLRUCache(4)
+	legacy.Add("1.2.3.4", true)
+	if err := legacy.ExportToFile(path); err != nil {
+		t.Fatalf("ExportToFile failed: %v", err)
+	}
+
+	var logBuf bytes.Buffer
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cache, _, err := geoblock.InitializeCache(ctx, snapshotTestOptions(t, path, &logBuf))
+	if err != nil {
+		t.Fatalf("InitializeCache failed: %v", err)
+	}
+	if _, ok := cache.Get("1.2.3.4"); !ok {
+		t.Fatalf("expected legacy snapshot to be loaded, log:\n%s", logBuf.String())
+	}
+}
+
+func TestSnapshotCorruptFileIsQuarantined(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip-cache.db")
+	if err := os.WriteFile(path, []byte("definitely-not-a-snapshot"), 0o600); err != nil {
+		t.Fatalf("seed corrupt file failed: %v", err)
+	}
+
+	var logBuf bytes.Buffer
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cache, _, err := geoblock.InitializeCache(ctx, snapshotTestOptions(t, path, &logBuf))
+	if err != nil {
+		t.Fatalf("InitializeCache failed: %v", err)
+	}
+	if cache.Length() != 0 {
+		t.Fatalf("expected empty cache after corrupt snapshot, got %d entries", cache.Length())
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected corrupt snapshot to be moved away, stat err=%v", err)
+	}
+	findQuarantinedSnapshot(t, path)
+}
+
+func TestSnapshotChecksumMismatchIsQuarantined(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip-cache.db")
+	writeSnapshotForTest(t, path)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read snapshot failed: %v", err)
+	}
+	data[len(data)-1] ^= 0xff // flip a payload byte
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("rewrite snapshot failed: %v", err)
+	}
+
+	var logBuf bytes.Buffer
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cache, _, err := geoblock.InitializeCache(ctx, snapshotTestOptions(t, path, &logBuf))
+	if err != nil {
+		t.Fatalf("InitializeCache failed: %v", err)
+	}
+	if _, ok := cache.Get("1.2.3.4"); ok {
+		t.Fatal("entry from a tampered snapshot must not be loaded")
+	}
+	if !strings.Contains(logBuf.String(), "checksum mismatch") {
+		t.Fatalf("expected checksum error in log, got:\n%s", logBuf.String())
+	}
+	findQuarantinedSnapshot(t, path)
+}
+
+func TestSnapshotCompressedRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip-cache.db")
+
+	var logBuf bytes.Buffer
+	opt := snapshotTestOptions(t, path, &logBuf)
+	opt.CacheCompress = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cache, persist, err := geoblock.InitializeCache(ctx, opt)
+	if err != nil {
+		t.Fatalf("InitializeCache(run1) failed: %v", err)
+	}
+	cache.Add("1.2.3.4", true)
+	persist.MarkDirty()
+	waitForFileNonEmpty(t, path, 2*time.Second)
+	cancel()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read snapshot failed: %v", err)
+	}
+	if !bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		t.Fatal("expected a gzip compressed snapshot")
+	}
+
+	// Compression is detected on import, independent of the current setting.
+	opt.CacheCompress = false
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+
+	cache, _, err = geoblock.InitializeCache(ctx2, opt)
+	if err != nil {
+		t.Fatalf("InitializeCache(run2) failed: %v", err)
+	}
+	if _, ok := cache.Get("1.2.3.4"); !ok {
+		t.Fatalf("expected entry from compressed snapshot, log:\n%s", logBuf.String())
+	}
+}
@@ -0,0 +1,40 @@
+package geoblock
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/PascalMinder/geoblock/iptrie"
+)
+
+// parseCountryOverrides parses the configured IP/CIDR to country code map
+// into a trie holding the country code of every range.
+func parseCountryOverrides(entries map[string]string) (*iptrie.Trie, error) {
+	overrides := iptrie.NewTrie()
+	for entry, country := range entries {
+		ipNets, err := parseIPNets([]string{entry})
+		if err != nil {
+			return nil, fmt.Errorf("country overrides: %w", err)
+		}
+
+		country = strings.ToUpper(strings.TrimSpace(country))
+		if !isCountryCode(country) {
+			return nil, fmt.Errorf("country overrides: invalid country code [%s] for [%s]", country, entry)
+		}
+
+		overrides.Insert(ipNets[0], country)
+	}
+
+	return overrides, nil
+}
+
+// countryOverride returns the country of the most specific override
+// containing the IP address.
+func (a *GeoBlock) countryOverride(ip net.IP) (string, bool) {
+	country, ok := a.countryOverrides.Lookup(ip)
+	if !ok {
+		return "", false
+	}
+	return country.(string), true
+}
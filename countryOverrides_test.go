@@ -0,0 +1,107 @@
+package geoblock_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	geoblock "github.com/PascalMinder/geoblock"
+)
+
+func TestCountryOverrideLongestPrefixWins(t *testing.T) {
+	var apiCalls int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&apiCalls, 1)
+		_, _ = rw.Write([]byte("CA"))
+	}))
+	defer server.Close()
+
+	cfg := createTesterConfig()
+	cfg.API = server.URL + "/{ip}"
+	cfg.Countries = append(cfg.Countries, "CH")
+	cfg.AddCountryHeader = true
+	cfg.CountryOverrides = map[string]string{
+		"99.220.0.0/16":   "DE",
+		"99.220.109.0/24": "ch",
+	}
+
+	ctx := context.Background()
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+
+	handler, err := geoblock.New(ctx, next, cfg, t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.Header.Add(xForwardedFor, caExampleIP)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	assertStatusCode(t, recorder.Result(), http.StatusOK)
+	assertRequestHeader(t, req, CountryHeader, "CH")
+
+	// only covered by the less specific override
+	assertStatusCode(t, geoblockRequest(handler, "99.220.1.1"), http.StatusForbidden)
+
+	if got := atomic.LoadInt32(&apiCalls); got != 0 {
+		t.Fatalf("expected overridden IPs not to be looked up, got %d API calls", got)
+	}
+}
+
+func TestCountryOverrideInvalidEntries(t *testing.T) {
+	for _, overrides := range []map[string]string{
+		{"not-an-ip": "CH"},
+		{"192.0.2.0/24": "CHE"},
+	} {
+		cfg := createTesterConfig()
+		cfg.Countries = append(cfg.Countries, "CH")
+		cfg.CountryOverrides = overrides
+
+		ctx := context.Background()
+		next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+
+		if _, err := geoblock.New(ctx, next, cfg, t.Name()); err == nil {
+			t.Fatalf("expected error for country overrides %v", overrides)
+		}
+	}
+}
+
+func TestCountryOverrideASNIsCached(t *testing.T) {
+	var asnCalls int32
+	asnServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&asnCalls, 1)
+		_, _ = rw.Write([]byte(`{"asn": 15796}`))
+	}))
+	defer asnServer.Close()
+
+	cfg := createTesterConfig()
+	cfg.Countries = append(cfg.Countries, "CH")
+	cfg.CountryOverrides = map[string]string{"99.220.109.0/24": "CH"}
+	cfg.ASNAPI = asnServer.URL + "/{ip}"
+	cfg.ASNAPIField = "asn"
+	cfg.AdminAPIPath = adminAPIPath
+	cfg.AdminAPIToken = adminAPIToken
+
+	ctx := context.Background()
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+
+	handler, err := geoblock.New(ctx, next, cfg, t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		assertStatusCode(t, geoblockRequest(handler, caExampleIP), http.StatusOK)
+	}
+
+	if got := atomic.LoadInt32(&asnCalls); got != 1 {
+		t.Fatalf("expected the ASN to be resolved once, got %d ASN API calls", got)
+	}
+
+	// the override must not leak into the cache shared with other middlewares
+	assertStatusCode(t, adminRequest(handler, http.MethodGet, "?ip="+caExampleIP, "127.0.0.1:4711", adminAPIToken),
+		http.StatusNotFound)
+}
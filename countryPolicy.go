@@ -0,0 +1,285 @@
+package geoblock
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	lru "github.com/PascalMinder/geoblock/lrucache"
+)
+
+const (
+	policyActionRateLimit = "ratelimit"
+	policyActionTarpit    = "tarpit"
+
+	rateLimitByIP      = "ip"
+	rateLimitByCountry = "country"
+
+	defaultRateLimitCacheSize = 10000
+)
+
+// CountryPolicy sets the action for the requests of countries, subdivisions
+// or country groups instead of allowing or denying them.
+type CountryPolicy struct {
+	Countries []string `yaml:"countries,omitempty"` // country or subdivision codes, or names of countryGroups
+	Action    string   `yaml:"action"`
+	Rate      float64  `yaml:"rate"`  // requests per second
+	Burst     int      `yaml:"burst"` // bucket size, at least 1
+	LimitBy   string   `yaml:"limitBy"`
+	DelayMs   int      `yaml:"delayMs"`  // tarpit delay before the denied response
+	JitterMs  int      `yaml:"jitterMs"` // random delay added to delayMs
+}
+
+// countryPolicies maps the country and subdivision codes and group names to
+// their policy.
+type countryPolicies struct {
+	byCode  map[string]*CountryPolicy
+	limiter *rateLimiter
+}
+
+func validateCountryPolicies(config *Config) []error {
+	var errs []error
+
+	groups := groupNames(config.CountryGroups)
+
+	seen := make(map[string]bool)
+	for i, policy := range config.CountryPolicies {
+		if len(policy.Countries) == 0 {
+			errs = append(errs, fmt.Errorf("country policy %d: no countries", i+1))
+		}
+		for _, code := range policy.Countries {
+			key := policyKey(code, groups)
+			if !groups[key] && !isCountryCode(key) && !isSubdivisionCode(key) {
+				errs = append(errs, fmt.Errorf("country policy %d: invalid country code or group [%s]", i+1, code))
+			}
+			if seen[key] {
+				errs = append(errs, fmt.Errorf("country policy %d: [%s] is used by another policy", i+1, code))
+			}
+			seen[key] = true
+		}
+
+		switch policy.Action {
+		case policyActionRateLimit:
+			errs = append(errs, validateRateLimit(i+1, policy)...)
+		case policyActionTarpit:
+			errs = append(errs, validateTarpit(i+1, policy)...)
+		default:
+			errs = append(errs, fmt.Errorf("country policy %d: invalid action [%s], must be %q or %q",
+				i+1, policy.Action, policyActionRateLimit, policyActionTarpit))
+		}
+	}
+
+	if config.RateLimitCacheSize < 0 || config.RateLimitCacheSize == 1 {
+		errs = append(errs, fmt.Errorf("invalid rate limit cache size [%d], must be at least 2", config.RateLimitCacheSize))
+	}
+
+	return errs
+}
+
+func validateRateLimit(index int, policy CountryPolicy) []error {
+	var errs []error
+
+	if policy.Rate <= 0 || math.IsInf(policy.Rate, 0) || math.IsNaN(policy.Rate) {
+		errs = append(errs, fmt.Errorf("country policy %d: rate must be a positive number of requests per second", index))
+	}
+	if policy.Burst < 1 {
+		errs = append(errs, fmt.Errorf("country policy %d: burst must be at least 1", index))
+	}
+
+	switch policy.LimitBy {
+	case "", rateLimitByIP, rateLimitByCountry:
+	default:
+		errs = append(errs, fmt.Errorf("country policy %d: invalid limitBy [%s], must be %q or %q",
+			index, policy.LimitBy, rateLimitByIP, rateLimitByCountry))
+	}
+
+	return errs
+}
+
+func buildCountryPolicies(config *Config, name string) (*countryPolicies, error) {
+	policies := &countryPolicies{byCode: make(map[string]*CountryPolicy)}
+	if len(config.CountryPolicies) == 0 {
+		return policies, nil
+	}
+
+	groups := groupNames(config.CountryGroups)
+	for i := range config.CountryPolicies {
+		policy := &config.CountryPolicies[i]
+		for _, code := range policy.Countries {
+			policies.byCode[policyKey(code, groups)] = policy
+		}
+	}
+
+	size := config.RateLimitCacheSize
+	if size == 0 {
+		size = defaultRateLimitCacheSize
+	}
+	limiter, err := sharedRateLimiter(size, name)
+	if err != nil {
+		return nil, fmt.Errorf("rate limit cache: %w", err)
+	}
+	policies.limiter = limiter
+
+	return policies, nil
+}
+
+var (
+	sharedRateLimitersMu sync.Mutex
+	sharedRateLimiters   = map[string]*rateLimiter{}
+)
+
+// sharedRateLimiter returns the rate limiter shared by the instances of the
+// middleware, so a limit applies to the middleware as a whole instead of per
+// router, and the buckets survive a config reload.
+func sharedRateLimiter(size int, name string) (*rateLimiter, error) {
+	sharedRateLimitersMu.Lock()
+	defer sharedRateLimitersMu.Unlock()
+
+	limiter, ok := sharedRateLimiters[name]
+	if !ok {
+		var err error
+		if limiter, err = newRateLimiter(size); err != nil {
+			return nil, err
+		}
+		sharedRateLimiters[name] = limiter
+	} else if size != limiter.buckets.Size() {
+		if _, err := limiter.buckets.Resize(size); err != nil {
+			return nil, err
+		}
+	}
+	return limiter, nil
+}
+
+func printCountryPolicyConfiguration(name string, config *Config, logger *log.Logger) {
+	for _, policy := range config.CountryPolicies {
+		if policy.Action == policyActionTarpit {
+			logger.Printf("%s: Country policy (%s): %v delay %dms jitter %dms", name,
+				policy.Action, policy.Countries, policy.DelayMs, policy.JitterMs)
+			continue
+		}
+		logger.Printf("%s: Country policy (%s): %v rate %g/s burst %d by %s", name,
+			policy.Action, policy.Countries, policy.Rate, policy.Burst, policy.LimitBy)
+	}
+}
+
+func groupNames(countryGroups map[string]string) map[string]bool {
+	groups := make(map[string]bool)
+	for _, group := range countryGroups {
+		groups[group] = true
+	}
+	return groups
+}
+
+// policyKey returns the group name as is and the country code upper-cased.
+func policyKey(code string, groups map[string]bool) string {
+	if groups[code] {
+		return code
+	}
+	return strings.ToUpper(code)
+}
+
+// countryPolicy returns the policy of the subdivision, the country or the
+// country group of the entry, in this order, and the code it is set for.
+func (a *GeoBlock) countryPolicy(entry ipEntry) (*CountryPolicy, string) {
+	if len(a.policies.byCode) == 0 {
+		return nil, ""
+	}
+	if policy, ok := a.policies.byCode[entry.Subdivision]; ok && len(entry.Subdivision) > 0 {
+		return policy, entry.Subdivision
+	}
+	if policy, ok := a.policies.byCode[entry.Country]; ok {
+		return policy, entry.Country
+	}
+	if group, ok := a.countryGroup(entry); ok {
+		if policy, ok := a.policies.byCode[group]; ok {
+			return policy, group
+		}
+	}
+	return nil, ""
+}
+
+// applyCountryPolicy applies the action of a country policy to the request.
+func (a *GeoBlock) applyCountryPolicy(requestIPAddr *net.IP, policy *CountryPolicy, code string) decision {
+	if policy.Action == policyActionTarpit {
+		a.infoLogger.Printf("%s: request denied [%s] for [%s] due to: %s", a.name, requestIPAddr, code, reasonTarpit)
+		return decision{allowed: false, reason: reasonTarpit, delay: tarpitDelay(policy)}
+	}
+
+	key := code
+	if policy.LimitBy != rateLimitByCountry {
+		key += "|" + requestIPAddr.String()
+	}
+
+	allowed, retryAfter := a.policies.limiter.take(key, policy.Rate, policy.Burst)
+	if !allowed {
+		a.infoLogger.Printf("%s: request denied [%s] for [%s] due to: %s", a.name, requestIPAddr, code, reasonRateLimited)
+		return decision{allowed: false, reason: reasonRateLimited, retryAfter: retryAfter}
+	}
+
+	if a.logAllowedRequests {
+		a.infoLogger.Printf("%s: request allowed [%s] for [%s] within the rate limit", a.name, requestIPAddr, code)
+	}
+	return decision{allowed: true, reason: reasonRateLimitPassed}
+}
+
+// writeRateLimited answers a rate limited request with 429 and the seconds
+// until the next request is accepted.
+func (a *GeoBlock) writeRateLimited(rw http.ResponseWriter, req *http.Request, result decision) {
+	seconds := int(math.Ceil(result.retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	rw.Header().Set("Retry-After", strconv.Itoa(seconds))
+	a.writeDeniedResponse(rw, req, http.StatusTooManyRequests, result)
+}
+
+// rateLimiter keeps the token buckets of the recently seen keys. Buckets of
+// evicted keys start full again.
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets *lru.LRUCache
+	now     func() time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(size int) (*rateLimiter, error) {
+	buckets, err := lru.NewLRUCache(size)
+	if err != nil {
+		return nil, err
+	}
+	return &rateLimiter{buckets: buckets, now: time.Now}, nil
+}
+
+// take removes a token from the bucket of the key, refilled at rate tokens
+// per second up to burst. If the bucket is empty, it returns false and the
+// time until the next token.
+func (l *rateLimiter) take(key string, rate float64, burst int) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	bucket := &tokenBucket{tokens: float64(burst), last: now}
+	if value, ok := l.buckets.Get(key); ok {
+		bucket = value.(*tokenBucket)
+		bucket.tokens = math.Min(float64(burst), bucket.tokens+now.Sub(bucket.last).Seconds()*rate)
+		bucket.last = now
+	} else {
+		l.buckets.Add(key, bucket)
+	}
+
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
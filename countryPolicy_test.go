@@ -0,0 +1,134 @@
+package geoblock_test
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	geoblock "github.com/PascalMinder/geoblock"
+)
+
+func TestRateLimitedCountry(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.Countries = append(cfg.Countries, "DE")
+	cfg.CountryPolicies = []geoblock.CountryPolicy{
+		{Countries: []string{"ch"}, Action: "ratelimit", Rate: 0.001, Burst: 2},
+	}
+
+	handler := createCountryAPIHandler(t, cfg, exampleCountries, nil)
+
+	assertStatusCode(t, geoblockRequest(handler, chExampleIP), http.StatusOK)
+	assertStatusCode(t, geoblockRequest(handler, chExampleIP), http.StatusOK)
+
+	resp := geoblockRequest(handler, chExampleIP)
+	assertStatusCode(t, resp, http.StatusTooManyRequests)
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err != nil || seconds < 999 || seconds > 1000 {
+		t.Fatalf("expected Retry-After of 1000 seconds, got %q", resp.Header.Get("Retry-After"))
+	}
+
+	// countries without a policy are still allowed or denied
+	assertStatusCode(t, geoblockRequest(handler, caExampleIP), http.StatusForbidden)
+}
+
+func TestRateLimitPerIPAndPerCountry(t *testing.T) {
+	for limitBy, expected := range map[string]int{
+		"ip":      http.StatusOK,
+		"country": http.StatusTooManyRequests,
+	} {
+		t.Run(limitBy, func(t *testing.T) {
+			cfg := createTesterConfig()
+			cfg.Countries = append(cfg.Countries, "DE")
+			cfg.CountryPolicies = []geoblock.CountryPolicy{
+				{Countries: []string{"CA"}, Action: "ratelimit", Rate: 0.001, Burst: 1, LimitBy: limitBy},
+			}
+
+			countries := map[string]string{caExampleIP: "CA", "99.220.109.149": "CA"}
+			handler := createCountryAPIHandler(t, cfg, countries, nil)
+
+			assertStatusCode(t, geoblockRequest(handler, caExampleIP), http.StatusOK)
+			assertStatusCode(t, geoblockRequest(handler, caExampleIP), http.StatusTooManyRequests)
+			assertStatusCode(t, geoblockRequest(handler, "99.220.109.149"), expected)
+		})
+	}
+}
+
+func TestRateLimitSharedPerMiddleware(t *testing.T) {
+	newConfig := func() *geoblock.Config {
+		cfg := createTesterConfig()
+		cfg.Countries = append(cfg.Countries, "DE")
+		cfg.CountryPolicies = []geoblock.CountryPolicy{
+			{Countries: []string{"CA"}, Action: "ratelimit", Rate: 0.001, Burst: 1, LimitBy: "country"},
+		}
+		return cfg
+	}
+
+	// one instance per router, both sharing the middleware name
+	first := createCountryAPIHandler(t, newConfig(), exampleCountries, nil)
+	second := createCountryAPIHandler(t, newConfig(), exampleCountries, nil)
+
+	assertStatusCode(t, geoblockRequest(first, caExampleIP), http.StatusOK)
+	assertStatusCode(t, geoblockRequest(second, caExampleIP), http.StatusTooManyRequests)
+}
+
+func TestRateLimitForCountryGroup(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.Countries = append(cfg.Countries, "DE")
+	cfg.CountryGroups = map[string]string{"CH": "dach", "AT": "dach"}
+	cfg.CountryPolicies = []geoblock.CountryPolicy{
+		{Countries: []string{"dach"}, Action: "ratelimit", Rate: 0.001, Burst: 1},
+	}
+
+	handler := createCountryAPIHandler(t, cfg, exampleCountries, nil)
+
+	assertStatusCode(t, geoblockRequest(handler, chExampleIP), http.StatusOK)
+	assertStatusCode(t, geoblockRequest(handler, chExampleIP), http.StatusTooManyRequests)
+}
+
+func TestRateLimitedResponseBody(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.Countries = append(cfg.Countries, "DE")
+	cfg.DeniedResponseTemplate = deniedTemplate
+	cfg.CountryPolicies = []geoblock.CountryPolicy{
+		{Countries: []string{"CA"}, Action: "ratelimit", Rate: 1, Burst: 1},
+	}
+
+	handler := createCountryAPIHandler(t, cfg, exampleCountries, nil)
+
+	deniedRequest(handler, "")
+	resp := deniedRequest(handler, "")
+
+	assertStatusCode(t, resp, http.StatusTooManyRequests)
+	assertResponseHeader(t, resp, "Retry-After", "1")
+	expected := "<h1>Not available in CA</h1><p>" + caExampleIP + " rate_limited req-42 429</p>"
+	if body := readBody(t, resp); body != expected {
+		t.Fatalf("expected body %q, got %q", expected, body)
+	}
+}
+
+func TestInvalidCountryPolicies(t *testing.T) {
+	for name, modify := range map[string]func(cfg *geoblock.Config){
+		"action": func(cfg *geoblock.Config) { cfg.CountryPolicies[0].Action = "throttle" },
+		"rate":   func(cfg *geoblock.Config) { cfg.CountryPolicies[0].Rate = 0 },
+		"burst":  func(cfg *geoblock.Config) { cfg.CountryPolicies[0].Burst = 0 },
+		"limitBy": func(cfg *geoblock.Config) {
+			cfg.CountryPolicies[0].LimitBy = "asn"
+		},
+		"no countries":  func(cfg *geoblock.Config) { cfg.CountryPolicies[0].Countries = nil },
+		"unknown group": func(cfg *geoblock.Config) { cfg.CountryPolicies[0].Countries = []string{"dach"} },
+		"cache size":    func(cfg *geoblock.Config) { cfg.RateLimitCacheSize = 1 },
+		"duplicate country": func(cfg *geoblock.Config) {
+			cfg.CountryPolicies = append(cfg.CountryPolicies, cfg.CountryPolicies[0])
+		},
+	} {
+		cfg := createTesterConfig()
+		cfg.Countries = append(cfg.Countries, "CH")
+		cfg.CountryPolicies = []geoblock.CountryPolicy{
+			{Countries: []string{"CA"}, Action: "ratelimit", Rate: 1, Burst: 1},
+		}
+		modify(cfg)
+
+		if _, err := newCountryAPIHandler(t, cfg, exampleCountries, nil); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package geoblock_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	geoblock "github.com/PascalMinder/geoblock"
+)
+
+func TestDeniedIPAddressTakesPrecedence(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.Countries = append(cfg.Countries, "CH")
+	cfg.AllowLocalRequests = true
+	cfg.AllowedIPAddresses = append(cfg.AllowedIPAddresses, chExampleIP)
+	cfg.DeniedIPAddresses = append(cfg.DeniedIPAddresses, chExampleIP, "192.168.0.0/16")
+
+	ctx := context.Background()
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+
+	handler, err := geoblock.New(ctx, next, cfg, t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// denied although explicitly allowed
+	assertStatusCode(t, geoblockRequest(handler, chExampleIP), http.StatusForbidden)
+	// denied although local requests are allowed
+	assertStatusCode(t, geoblockRequest(handler, privateRangeIP), http.StatusForbidden)
+	// other local addresses are still allowed
+	assertStatusCode(t, geoblockRequest(handler, "10.0.0.1"), http.StatusOK)
+}
+
+func TestDeniedIPAddressExplainReason(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.Countries = append(cfg.Countries, "CH")
+	cfg.DeniedIPAddresses = append(cfg.DeniedIPAddresses, "82.220.0.0/16")
+	cfg.ExplainSecret = explainSecret
+
+	ctx := context.Background()
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+
+	handler, err := geoblock.New(ctx, next, cfg, t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.Header.Add(xForwardedFor, chExampleIP)
+	req.Header.Set("X-GeoBlock-Explain", explainSecret)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	assertStatusCode(t, recorder.Result(), http.StatusForbidden)
+	assertResponseHeader(t, recorder.Result(), "X-GeoBlock-Decision",
+		"deny; reason=denied_ip; ip="+chExampleIP+"; country=")
+}
+
+func TestDeniedIPAddressInvalidEntry(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.Countries = append(cfg.Countries, "CH")
+	cfg.DeniedIPAddresses = append(cfg.DeniedIPAddresses, "192.0.2.0/33")
+
+	ctx := context.Background()
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+
+	if _, err := geoblock.New(ctx, next, cfg, t.Name()); err == nil {
+		t.Fatal("expected error for invalid denied IP address")
+	}
+}
@@ -0,0 +1,381 @@
+package geoblock
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	requestIDHeader = "X-Request-Id"
+
+	contentTypeHTML        = "text/html; charset=utf-8"
+	contentTypeProblemJSON = "application/problem+json"
+)
+
+// deniedResponseData is passed to the denied response template.
+type deniedResponseData struct {
+	Country    string
+	IP         string
+	RequestID  string
+	Reason     string
+	StatusCode int
+	Language   string // tag of the localized template, empty for the default template
+}
+
+// problemDetails is the RFC 9457 body returned to API clients.
+type problemDetails struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail"`
+	Instance  string `json:"instance,omitempty"`
+	Country   string `json:"country,omitempty"`
+	IP        string `json:"ip,omitempty"`
+	RequestID string `json:"requestId"`
+	Reason    string `json:"reason"`
+}
+
+// deniedResponse writes the body of denied requests: the HTML template for
+// browsers and problem details for API clients.
+type deniedResponse struct {
+	template  *template.Template            // nil if none is configured
+	localized map[string]*template.Template // keyed by lower case language tag
+	name      string
+	logger    *log.Logger
+}
+
+func validateDeniedResponseConfig(config *Config) []error {
+	var errs []error
+
+	fallback, err := loadDeniedResponseTemplate(config)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	localized, err := loadLocalizedTemplates(config)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	if fallback == nil && len(localized) != 0 && len(errs) == 0 {
+		errs = append(errs, errors.New(
+			"localized denied response templates require deniedResponseTemplate or deniedResponseTemplateFile as default"))
+	}
+
+	return errs
+}
+
+// loadDeniedResponseTemplate parses the inline or file template, or returns
+// nil if none is configured.
+func loadDeniedResponseTemplate(config *Config) (*template.Template, error) {
+	text := config.DeniedResponseTemplate
+	switch {
+	case len(config.DeniedResponseTemplate) != 0 && len(config.DeniedResponseTemplateFile) != 0:
+		return nil, errors.New("deniedResponseTemplate and deniedResponseTemplateFile are mutually exclusive")
+	case len(config.DeniedResponseTemplateFile) != 0:
+		data, err := os.ReadFile(config.DeniedResponseTemplateFile)
+		if err != nil {
+			return nil, fmt.Errorf("denied response template: %w", err)
+		}
+		text = string(data)
+	case len(text) == 0:
+		return nil, nil
+	}
+
+	tmpl, err := template.New("denied").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("denied response template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// loadLocalizedTemplates parses the inline and file templates keyed by
+// language tag, e.g. "de" or "fr-CH".
+func loadLocalizedTemplates(config *Config) (map[string]*template.Template, error) {
+	texts := make(map[string]string, len(config.DeniedResponseTemplates)+len(config.DeniedResponseTemplateFiles))
+	for tag, text := range config.DeniedResponseTemplates {
+		texts[strings.ToLower(tag)] = text
+	}
+
+	for tag, path := range config.DeniedResponseTemplateFiles {
+		if _, ok := texts[strings.ToLower(tag)]; ok {
+			return nil, fmt.Errorf("denied response template [%s] configured inline and as file", tag)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("denied response template [%s]: %w", tag, err)
+		}
+		texts[strings.ToLower(tag)] = string(data)
+	}
+
+	templates := make(map[string]*template.Template, len(texts))
+	for tag, text := range texts {
+		if !isLanguageTag(tag) {
+			return nil, fmt.Errorf("denied response template: invalid language tag [%s]", tag)
+		}
+		tmpl, err := template.New("denied-" + tag).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("denied response template [%s]: %w", tag, err)
+		}
+		templates[tag] = tmpl
+	}
+	return templates, nil
+}
+
+// isLanguageTag reports whether the tag is a well-formed language tag:
+// subtags of one to eight letters or digits, separated by hyphens.
+func isLanguageTag(tag string) bool {
+	for _, subtag := range strings.Split(tag, "-") {
+		if len(subtag) == 0 || len(subtag) > 8 {
+			return false
+		}
+		for _, r := range subtag {
+			if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func buildDeniedResponse(config *Config, logger *log.Logger, name string) (*deniedResponse, error) {
+	tmpl, err := loadDeniedResponseTemplate(config)
+	if err != nil {
+		return nil, err
+	}
+
+	localized, err := loadLocalizedTemplates(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &deniedResponse{template: tmpl, localized: localized, name: name, logger: logger}, nil
+}
+
+// writeDeniedResponse writes the status and the body of a denied request:
+// problem details if the client prefers JSON, otherwise the template if one
+// is configured.
+func (a *GeoBlock) writeDeniedResponse(rw http.ResponseWriter, req *http.Request, status int, result decision) {
+	if !bodyAllowedForStatus(status) {
+		rw.WriteHeader(status)
+		return
+	}
+	a.deniedResponse.write(rw, req, status, result)
+}
+
+func (d *deniedResponse) write(rw http.ResponseWriter, req *http.Request, status int, result decision) {
+	data := deniedResponseData{
+		Country:    result.entry.Country,
+		RequestID:  requestID(req),
+		Reason:     result.reason,
+		StatusCode: status,
+	}
+	if result.ip != nil {
+		data.IP = result.ip.String()
+	}
+
+	rw.Header().Set(requestIDHeader, data.RequestID)
+	rw.Header().Add("Vary", "Accept")
+
+	if prefersProblemJSON(req.Header.Get("Accept")) {
+		body, err := json.Marshal(problemDetails{
+			Type:      "about:blank",
+			Title:     http.StatusText(status),
+			Status:    status,
+			Detail:    "request denied due to: " + data.Reason,
+			Instance:  req.URL.Path,
+			Country:   data.Country,
+			IP:        data.IP,
+			RequestID: data.RequestID,
+			Reason:    data.Reason,
+		})
+		if err != nil {
+			d.logger.Printf("%s: failed to encode denied response: %v", d.name, err)
+			rw.WriteHeader(status)
+			return
+		}
+		writeBody(rw, status, contentTypeProblemJSON, body)
+		return
+	}
+
+	if d.template == nil {
+		rw.WriteHeader(status)
+		return
+	}
+
+	tmpl := d.template
+	if len(d.localized) != 0 {
+		rw.Header().Add("Vary", "Accept-Language")
+		if language, localized := d.selectTemplate(req.Header.Get("Accept-Language")); localized != nil {
+			tmpl, data.Language = localized, language
+			rw.Header().Set("Content-Language", language)
+		}
+	}
+
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, data); err != nil {
+		d.logger.Printf("%s: failed to render denied response template: %v", d.name, err)
+		rw.WriteHeader(status)
+		return
+	}
+	writeBody(rw, status, contentTypeHTML, body.Bytes())
+}
+
+// selectTemplate returns the localized template for the most preferred
+// language of the Accept-Language header, or nil for the default template.
+// A language range matches the template with the same tag or, as in the
+// lookup of RFC 4647, the range truncated at a hyphen: "de-CH" falls back to
+// "de". Languages with equal quality are tried in the order of the header.
+func (d *deniedResponse) selectTemplate(acceptLanguage string) (string, *template.Template) {
+	ranges := parseQualityList(acceptLanguage)
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].quality > ranges[j].quality
+	})
+
+	for _, r := range ranges {
+		if r.quality == 0 {
+			break
+		}
+		if r.value == "*" {
+			return "", nil
+		}
+		for tag := r.value; len(tag) != 0; tag = truncateLanguageRange(tag) {
+			if tmpl, ok := d.localized[tag]; ok {
+				return tag, tmpl
+			}
+		}
+	}
+	return "", nil
+}
+
+// truncateLanguageRange removes the last subtag of the language range and
+// then a trailing single-letter subtag, e.g. "zh-hant-x-a" becomes "zh-hant".
+func truncateLanguageRange(tag string) string {
+	i := strings.LastIndex(tag, "-")
+	if i < 0 {
+		return ""
+	}
+	tag = tag[:i]
+	if i = strings.LastIndex(tag, "-"); i >= 0 && i == len(tag)-2 {
+		tag = tag[:i]
+	}
+	return tag
+}
+
+func writeBody(rw http.ResponseWriter, status int, contentType string, body []byte) {
+	rw.Header().Set("Content-Type", contentType)
+	rw.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	rw.WriteHeader(status)
+	_, _ = rw.Write(body)
+}
+
+// requestID returns the ID of the request set by a proxy in front, or a new
+// random one.
+func requestID(req *http.Request) string {
+	if id := req.Header.Get(requestIDHeader); len(id) != 0 {
+		return id
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(id)
+}
+
+func bodyAllowedForStatus(status int) bool {
+	return status >= http.StatusOK && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// prefersProblemJSON reports whether the Accept header ranks JSON above
+// HTML. On equal quality the more specific media range wins, e.g. for
+// "application/json, */*". Clients without an Accept header get HTML.
+func prefersProblemJSON(accept string) bool {
+	ranges := parseQualityList(accept)
+
+	jsonQuality, jsonSpecificity := mediaTypeQuality(ranges, "application/problem+json")
+	if q, s := mediaTypeQuality(ranges, "application/json"); q > jsonQuality || (q == jsonQuality && s > jsonSpecificity) {
+		jsonQuality, jsonSpecificity = q, s
+	}
+	htmlQuality, htmlSpecificity := mediaTypeQuality(ranges, "text/html")
+
+	if jsonQuality != htmlQuality {
+		return jsonQuality > htmlQuality
+	}
+	return jsonQuality > 0 && jsonSpecificity > htmlSpecificity
+}
+
+// mediaTypeQuality returns the quality and specificity of the most specific
+// media range matching the media type: "text/html" (2) before "text/*" (1)
+// before "*/*" (0). The specificity is -1 if no range matches.
+func mediaTypeQuality(ranges []qualityValue, mediaType string) (float64, int) {
+	typ, _, _ := strings.Cut(mediaType, "/")
+
+	quality, specificity := 0.0, -1
+	for _, r := range ranges {
+		var s int
+		switch r.value {
+		case mediaType:
+			s = 2
+		case typ + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		default:
+			continue
+		}
+		if s > specificity {
+			quality, specificity = r.quality, s
+		}
+	}
+	return quality, specificity
+}
+
+// qualityValue is an entry of a header with quality values (RFC 9110,
+// section 12.4.2), e.g. Accept or Accept-Language.
+type qualityValue struct {
+	value   string // lower case
+	quality float64
+}
+
+// parseQualityList parses a header such as "text/html, application/*;q=0.8".
+// Entries with an invalid quality are ignored.
+func parseQualityList(header string) []qualityValue {
+	var values []qualityValue
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		value := strings.ToLower(strings.TrimSpace(params[0]))
+		if len(value) == 0 {
+			continue
+		}
+
+		entry := qualityValue{value: value, quality: 1}
+		for _, param := range params[1:] {
+			key, q, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if !ok || !strings.EqualFold(strings.TrimSpace(key), "q") {
+				continue
+			}
+			quality, err := strconv.ParseFloat(strings.TrimSpace(q), 64)
+			if err != nil || quality < 0 || quality > 1 {
+				entry.quality = -1
+				break
+			}
+			entry.quality = quality
+		}
+		if entry.quality >= 0 {
+			values = append(values, entry)
+		}
+	}
+	return values
+}
@@ -0,0 +1,271 @@
+package geoblock_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	geoblock "github.com/PascalMinder/geoblock"
+)
+
+const deniedTemplate = `<h1>Not available in {{.Country}}</h1><p>{{.IP}} {{.Reason}} {{.RequestID}} {{.StatusCode}}</p>`
+
+func createDeniedResponseHandler(t *testing.T, cfg *geoblock.Config) http.Handler {
+	t.Helper()
+
+	cfg.Countries = append(cfg.Countries, "CH")
+	return createCountryAPIHandler(t, cfg, exampleCountries, nil)
+}
+
+func deniedRequest(handler http.Handler, accept string) *http.Response {
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/shop", nil)
+	req.Header.Add(xForwardedFor, caExampleIP)
+	req.Header.Set("X-Request-Id", "req-42")
+	if len(accept) != 0 {
+		req.Header.Set("Accept", accept)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder.Result()
+}
+
+func readBody(t *testing.T, resp *http.Response) string {
+	t.Helper()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestDeniedResponseTemplate(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.DeniedResponseTemplate = deniedTemplate
+
+	resp := deniedRequest(createDeniedResponseHandler(t, cfg), "text/html,application/xhtml+xml,*/*;q=0.8")
+
+	assertStatusCode(t, resp, http.StatusForbidden)
+	assertResponseHeader(t, resp, "Content-Type", "text/html; charset=utf-8")
+	assertResponseHeader(t, resp, "X-Request-Id", "req-42")
+
+	expected := "<h1>Not available in CA</h1><p>" + caExampleIP + " country_not_allowed req-42 403</p>"
+	if body := readBody(t, resp); body != expected {
+		t.Fatalf("expected body %q, got %q", expected, body)
+	}
+}
+
+func TestDeniedResponseProblemJSON(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.DeniedResponseTemplate = deniedTemplate
+	cfg.HTTPStatusCodeDeniedRequest = http.StatusUnavailableForLegalReasons
+
+	resp := deniedRequest(createDeniedResponseHandler(t, cfg), "application/json")
+
+	assertStatusCode(t, resp, http.StatusUnavailableForLegalReasons)
+	assertResponseHeader(t, resp, "Content-Type", "application/problem+json")
+
+	var problem map[string]interface{}
+	if err := json.Unmarshal([]byte(readBody(t, resp)), &problem); err != nil {
+		t.Fatal(err)
+	}
+	for key, expected := range map[string]interface{}{
+		"type":      "about:blank",
+		"title":     "Unavailable For Legal Reasons",
+		"status":    float64(http.StatusUnavailableForLegalReasons),
+		"instance":  "/shop",
+		"country":   "CA",
+		"ip":        caExampleIP,
+		"requestId": "req-42",
+		"reason":    "country_not_allowed",
+	} {
+		if problem[key] != expected {
+			t.Errorf("problem %s: expected %v, got %v", key, expected, problem[key])
+		}
+	}
+}
+
+func TestDeniedResponseNegotiation(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.DeniedResponseTemplate = deniedTemplate
+
+	handler := createDeniedResponseHandler(t, cfg)
+
+	for accept, expected := range map[string]string{
+		"":                                      "text/html; charset=utf-8",
+		"*/*":                                   "text/html; charset=utf-8",
+		"application/json, text/plain, */*":     "application/problem+json",
+		"application/problem+json":              "application/problem+json",
+		"text/html;q=0.5, application/json":     "application/problem+json",
+		"application/json;q=0.5, text/html":     "text/html; charset=utf-8",
+		"application/*, text/html":              "text/html; charset=utf-8",
+		"text/html;q=0, */*":                    "application/problem+json",
+		"application/json;q=invalid, text/html": "text/html; charset=utf-8",
+	} {
+		resp := deniedRequest(handler, accept)
+		if got := resp.Header.Get("Content-Type"); got != expected {
+			t.Errorf("Accept %q: expected content type %q, got %q", accept, expected, got)
+		}
+	}
+}
+
+func TestDeniedResponseWithoutTemplate(t *testing.T) {
+	handler := createDeniedResponseHandler(t, createTesterConfig())
+
+	// browsers get an empty body
+	resp := deniedRequest(handler, "text/html")
+	assertStatusCode(t, resp, http.StatusForbidden)
+	if body := readBody(t, resp); len(body) != 0 {
+		t.Fatalf("expected empty body, got %q", body)
+	}
+
+	// API clients get problem details
+	resp = deniedRequest(handler, "application/json")
+	assertStatusCode(t, resp, http.StatusForbidden)
+	assertResponseHeader(t, resp, "Content-Type", "application/problem+json")
+	if body := readBody(t, resp); !strings.Contains(body, `"reason":"country_not_allowed"`) {
+		t.Fatalf("expected problem details, got %q", body)
+	}
+}
+
+func TestDeniedResponseTemplateFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "denied.html")
+	if err := os.WriteFile(path, []byte(`<p>{{.Country}}: <script>{{.Reason}}</script></p>`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := createTesterConfig()
+	cfg.DeniedResponseTemplateFile = path
+
+	body := readBody(t, deniedRequest(createDeniedResponseHandler(t, cfg), ""))
+	if !strings.HasPrefix(body, "<p>CA: <script>") || !strings.Contains(body, `"country_not_allowed"`) {
+		t.Fatalf("expected rendered and escaped template, got %q", body)
+	}
+}
+
+func TestInvalidDeniedResponseTemplate(t *testing.T) {
+	for name, modify := range map[string]func(cfg *geoblock.Config){
+		"invalid template": func(cfg *geoblock.Config) { cfg.DeniedResponseTemplate = "{{.Country" },
+		"missing file":     func(cfg *geoblock.Config) { cfg.DeniedResponseTemplateFile = "/does/not/exist.html" },
+		"both": func(cfg *geoblock.Config) {
+			cfg.DeniedResponseTemplate = deniedTemplate
+			cfg.DeniedResponseTemplateFile = "denied.html"
+		},
+	} {
+		cfg := createTesterConfig()
+		cfg.Countries = append(cfg.Countries, "CH")
+		modify(cfg)
+
+		if _, err := newCountryAPIHandler(t, cfg, exampleCountries, nil); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestLocalizedDeniedResponse(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "fr.html")
+	if err := os.WriteFile(path, []byte(`fr {{.Language}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := createTesterConfig()
+	cfg.DeniedResponseTemplate = `default`
+	cfg.DeniedResponseTemplates = map[string]string{
+		"de":         `de`,
+		"de-CH":      `de-ch`,
+		"zh-Hant":    `zh-hant`,
+		"pt-BR":      `pt-br`,
+		"i-klingon":  `tlh`,
+		"sr-Latn-RS": `sr`,
+	}
+	cfg.DeniedResponseTemplateFiles = map[string]string{"fr": path}
+
+	handler := createDeniedResponseHandler(t, cfg)
+
+	for acceptLanguage, expected := range map[string]string{
+		"":                            "default",
+		"*":                           "default",
+		"en-US,en;q=0.9":              "default",
+		"de":                          "de",
+		"DE-ch":                       "de-ch",
+		"de-AT":                       "de",
+		"de-CH-1996":                  "de-ch",
+		"fr-CA, fr;q=0.9":             "fr fr",
+		"en;q=0.5, de;q=0.8":          "de",
+		"en, *;q=0.5":                 "default",
+		"de;q=0, fr":                  "fr fr",
+		"de;q=0":                      "default",
+		"pt, de":                      "de",
+		"zh-Hant-x-private":           "zh-hant",
+		"de;q=0.8, fr;q=0.8":          "de",
+		"de;q=nope, fr":               "fr fr",
+		"  de-ch ; q=1.0 , fr;q=0.1 ": "de-ch",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.Header.Add(xForwardedFor, caExampleIP)
+		if len(acceptLanguage) != 0 {
+			req.Header.Set("Accept-Language", acceptLanguage)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		resp := recorder.Result()
+		if body := readBody(t, resp); body != expected {
+			t.Errorf("Accept-Language %q: expected %q, got %q", acceptLanguage, expected, body)
+		}
+		if !strings.Contains(strings.Join(resp.Header.Values("Vary"), ","), "Accept-Language") {
+			t.Errorf("Accept-Language %q: expected Vary: Accept-Language", acceptLanguage)
+		}
+	}
+}
+
+func TestLocalizedDeniedResponseContentLanguage(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.DeniedResponseTemplate = `default`
+	cfg.DeniedResponseTemplates = map[string]string{"de": `de`}
+
+	handler := createDeniedResponseHandler(t, cfg)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.Header.Add(xForwardedFor, caExampleIP)
+	req.Header.Set("Accept-Language", "de-DE")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	assertResponseHeader(t, recorder.Result(), "Content-Language", "de")
+}
+
+func TestInvalidLocalizedDeniedResponseTemplates(t *testing.T) {
+	for name, modify := range map[string]func(cfg *geoblock.Config){
+		"without default": func(cfg *geoblock.Config) {
+			cfg.DeniedResponseTemplates = map[string]string{"de": `de`}
+		},
+		"invalid tag": func(cfg *geoblock.Config) {
+			cfg.DeniedResponseTemplate = `default`
+			cfg.DeniedResponseTemplates = map[string]string{"de_CH": `de`}
+		},
+		"inline and file": func(cfg *geoblock.Config) {
+			cfg.DeniedResponseTemplate = `default`
+			cfg.DeniedResponseTemplates = map[string]string{"de": `de`}
+			cfg.DeniedResponseTemplateFiles = map[string]string{"DE": "de.html"}
+		},
+		"invalid template": func(cfg *geoblock.Config) {
+			cfg.DeniedResponseTemplate = `default`
+			cfg.DeniedResponseTemplates = map[string]string{"de": `{{.Country`}
+		},
+	} {
+		cfg := createTesterConfig()
+		cfg.Countries = append(cfg.Countries, "CH")
+		modify(cfg)
+
+		if _, err := newCountryAPIHandler(t, cfg, exampleCountries, nil); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package geoblock
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	explainHeader         = "X-GeoBlock-Explain"
+	explainQueryParameter = "geoblock-explain"
+	decisionHeader        = "X-GeoBlock-Decision"
+)
+
+// Reason codes used in decision traces.
+const (
+	reasonExcludedPath          = "excluded_path"
+	reasonInvalidIP             = "invalid_ip"
+	reasonNoClientIP            = "no_client_ip"
+	reasonDeniedIP              = "denied_ip"
+	reasonAllowedIP             = "allowed_ip"
+	reasonLocalAllowed          = "local_ip_allowed"
+	reasonLocalDenied           = "local_ip_denied"
+	reasonDeniedASN             = "denied_asn"
+	reasonAllowedASN            = "allowed_asn"
+	reasonLookupFailed          = "lookup_failed"
+	reasonAPIFailureIgnored     = "api_failure_ignored"
+	reasonAPITimeoutIgnored     = "api_timeout_ignored"
+	reasonUnknownCountry        = "unknown_country"
+	reasonCountryAllowed        = "country_allowed"
+	reasonCountryNotAllowed     = "country_not_allowed"
+	reasonUnknownCountryAllowed = "unknown_country_allowed"
+	reasonSteering              = "steering"
+	reasonRateLimited           = "rate_limited"
+	reasonRateLimitPassed       = "rate_limit_passed"
+	reasonBanned                = "banned"
+	reasonTarpit                = "tarpit"
+	reasonBypassToken           = "bypass_token"
+	reasonVerifiedBot           = "verified_bot"
+)
+
+// Lookup sources used in decision traces.
+const (
+	sourceCache    = "cache"
+	sourceHeader   = "header"
+	sourceAPI      = "api"
+	sourceOverride = "override"
+)
+
+const (
+	verdictAllow = "allow"
+	verdictDeny  = "deny"
+)
+
+type explainContextKey struct{}
+
+// explainTrace records how a request was evaluated. It is only created for
+// requests carrying the configured explain secret; every method is a no-op on
+// a nil trace, so the decision path can record steps unconditionally.
+type explainTrace struct {
+	Path         string       `json:"path"`
+	Mode         string       `json:"mode"`
+	CollectedIPs []string     `json:"collectedIps"`
+	EvaluatedIPs []string     `json:"evaluatedIps"`
+	IPs          []*explainIP `json:"ips"`
+	Verdict      string       `json:"verdict"`
+	Reason       string       `json:"reason"`
+
+	respond bool // answer with the trace instead of forwarding the request
+}
+
+// explainIP is the trace of a single evaluated IP address.
+type explainIP struct {
+	IP                string `json:"ip"`
+	ExplicitlyDenied  bool   `json:"explicitlyDenied"`
+	ExplicitlyAllowed bool   `json:"explicitlyAllowed"`
+	Local             bool   `json:"local"`
+	CacheHit          bool   `json:"cacheHit"`
+	CacheAge          string `json:"cacheAge,omitempty"`
+	Refreshed         bool   `json:"refreshed,omitempty"`
+	Source            string `json:"source,omitempty"`
+	Country           string `json:"country,omitempty"`
+	ASN               uint32 `json:"asn,omitempty"`
+	Subdivision       string `json:"subdivision,omitempty"`
+	Verdict           string `json:"verdict,omitempty"`
+	Reason            string `json:"reason,omitempty"`
+}
+
+// startExplain returns a trace (and a request carrying it) if explaining is
+// enabled and the request presents the secret; otherwise the trace is nil.
+func (a *GeoBlock) startExplain(req *http.Request) (*explainTrace, *http.Request) {
+	if len(a.explainSecret) == 0 {
+		return nil, req
+	}
+	secret := req.Header.Get(explainHeader)
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(a.explainSecret)) != 1 {
+		return nil, req
+	}
+
+	mode := "whitelist"
+	switch {
+	case a.steeringMode:
+		mode = "steering"
+	case a.blackListMode:
+		mode = "blacklist"
+	}
+	trace := &explainTrace{
+		Path:    req.Host + req.URL.Path,
+		Mode:    mode,
+		respond: req.URL.Query().Has(explainQueryParameter),
+	}
+	return trace, req.WithContext(context.WithValue(req.Context(), explainContextKey{}, trace))
+}
+
+func explainFrom(req *http.Request) *explainTrace {
+	trace, _ := req.Context().Value(explainContextKey{}).(*explainTrace)
+	return trace
+}
+
+func (t *explainTrace) collected(collected, evaluated []*net.IP) {
+	if t == nil {
+		return
+	}
+	t.CollectedIPs = ipStrings(collected)
+	t.EvaluatedIPs = ipStrings(evaluated)
+}
+
+// beginIP starts the trace of the next evaluated IP address.
+func (t *explainTrace) beginIP(ip *net.IP) {
+	if t == nil {
+		return
+	}
+	t.IPs = append(t.IPs, &explainIP{IP: ip.String()})
+}
+
+func (t *explainTrace) current() *explainIP {
+	if t == nil || len(t.IPs) == 0 {
+		return nil
+	}
+	return t.IPs[len(t.IPs)-1]
+}
+
+func (t *explainTrace) explicitlyDenied() {
+	if ip := t.current(); ip != nil {
+		ip.ExplicitlyDenied = true
+	}
+}
+
+func (t *explainTrace) explicitlyAllowed() {
+	if ip := t.current(); ip != nil {
+		ip.ExplicitlyAllowed = true
+	}
+}
+
+func (t *explainTrace) local() {
+	if ip := t.current(); ip != nil {
+		ip.Local = true
+	}
+}
+
+func (t *explainTrace) cacheHit(entry ipEntry) {
+	if ip := t.current(); ip != nil {
+		ip.CacheHit = true
+		ip.CacheAge = time.Since(entry.Timestamp).Round(time.Second).String()
+		ip.Source = sourceCache
+		ip.Country = entry.Country
+	}
+}
+
+func (t *explainTrace) refreshed() {
+	if ip := t.current(); ip != nil {
+		ip.Refreshed = true
+	}
+}
+
+func (t *explainTrace) lookup(source, country string) {
+	if ip := t.current(); ip != nil {
+		ip.Source = source
+		ip.Country = country
+	}
+}
+
+// details records the ASN and subdivision of the current IP address.
+func (t *explainTrace) details(entry ipEntry) {
+	if ip := t.current(); ip != nil {
+		ip.ASN = entry.ASN
+		ip.Subdivision = entry.Subdivision
+	}
+}
+
+// decide records the verdict for the current IP address.
+func (t *explainTrace) decide(allowed bool, reason string) {
+	if ip := t.current(); ip != nil {
+		ip.Verdict = verdictOf(allowed)
+		ip.Reason = reason
+	}
+}
+
+// finish records the final verdict of the request.
+func (t *explainTrace) finish(allowed bool, reason string) {
+	if t == nil {
+		return
+	}
+	t.Verdict = verdictOf(allowed)
+	t.Reason = reason
+}
+
+// finalReason is the reason of the deciding IP address.
+func (t *explainTrace) finalReason() string {
+	if ip := t.deciding(); ip != nil {
+		return ip.Reason
+	}
+	return reasonNoClientIP
+}
+
+// write answers the request with the trace (explain endpoint) or adds a short
+// summary header to the response. It reports whether the response was written.
+func (t *explainTrace) write(rw http.ResponseWriter) bool {
+	if t == nil {
+		return false
+	}
+	if t.respond {
+		writeJSON(rw, http.StatusOK, t)
+		return true
+	}
+
+	summary := fmt.Sprintf("%s; reason=%s", t.Verdict, t.Reason)
+	if ip := t.deciding(); ip != nil {
+		summary += fmt.Sprintf("; ip=%s; country=%s", ip.IP, ip.Country)
+	}
+	rw.Header().Set(decisionHeader, summary)
+	return false
+}
+
+// deciding returns the first denied IP address, or the last evaluated one if
+// all were allowed.
+func (t *explainTrace) deciding() *explainIP {
+	if t == nil {
+		return nil
+	}
+	for _, ip := range t.IPs {
+		if ip.Verdict == verdictDeny {
+			return ip
+		}
+	}
+	return t.current()
+}
+
+func verdictOf(allowed bool) string {
+	if allowed {
+		return verdictAllow
+	}
+	return verdictDeny
+}
+
+func ipStrings(ips []*net.IP) []string {
+	parts := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		parts = append(parts, ip.String())
+	}
+	return parts
+}
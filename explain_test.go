@@ -0,0 +1,99 @@
+package geoblock_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	geoblock "github.com/PascalMinder/geoblock"
+)
+
+const explainSecret = "expl41n"
+
+func createExplainConfig() *geoblock.Config {
+	cfg := createTesterConfig()
+	cfg.Countries = append(cfg.Countries, "CH")
+	cfg.ExplainSecret = explainSecret
+	return cfg
+}
+
+func TestExplainEndpointReturnsTrace(t *testing.T) {
+	called := false
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) { called = true })
+	handler := createCountryAPIHandler(t, createExplainConfig(), exampleCountries, next)
+
+	for _, wantCacheHit := range []bool{false, true} {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/foo?geoblock-explain", nil)
+		req.Header.Add(xForwardedFor, caExampleIP)
+		req.Header.Set("X-GeoBlock-Explain", explainSecret)
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		assertStatusCode(t, recorder.Result(), http.StatusOK)
+
+		var trace struct {
+			Verdict string `json:"verdict"`
+			Reason  string `json:"reason"`
+			IPs     []struct {
+				IP       string `json:"ip"`
+				CacheHit bool   `json:"cacheHit"`
+				Source   string `json:"source"`
+				Country  string `json:"country"`
+			} `json:"ips"`
+		}
+		if err := json.NewDecoder(recorder.Body).Decode(&trace); err != nil {
+			t.Fatalf("decode trace failed: %v", err)
+		}
+		if trace.Verdict != "deny" || trace.Reason != "country_not_allowed" || len(trace.IPs) != 1 {
+			t.Fatalf("unexpected trace: %+v", trace)
+		}
+		ip := trace.IPs[0]
+		if ip.IP != caExampleIP || ip.Country != "CA" || ip.CacheHit != wantCacheHit {
+			t.Fatalf("unexpected IP trace (cache hit expected: %t): %+v", wantCacheHit, ip)
+		}
+	}
+
+	if called {
+		t.Fatal("explain endpoint must not forward the request")
+	}
+}
+
+func TestExplainHeaderIsAddedToResponse(t *testing.T) {
+	next := http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("X-GeoBlock-Explain") != "" {
+			t.Error("explain secret must not be forwarded")
+		}
+	})
+	cfg := createExplainConfig()
+	cfg.AllowLocalRequests = true
+	handler := createCountryAPIHandler(t, cfg, exampleCountries, next)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.Header.Add(xForwardedFor, privateRangeIP)
+	req.Header.Set("X-GeoBlock-Explain", explainSecret)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	assertStatusCode(t, recorder.Result(), http.StatusOK)
+	assertResponseHeader(t, recorder.Result(), "X-GeoBlock-Decision",
+		"allow; reason=local_ip_allowed; ip="+privateRangeIP+"; country=")
+}
+
+func TestExplainRequiresSecret(t *testing.T) {
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+	handler := createCountryAPIHandler(t, createExplainConfig(), exampleCountries, next)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/?geoblock-explain", nil)
+	req.Header.Add(xForwardedFor, caExampleIP)
+	req.Header.Set("X-GeoBlock-Explain", "wrong")
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	assertStatusCode(t, recorder.Result(), http.StatusForbidden)
+	if got := recorder.Result().Header.Get("X-GeoBlock-Decision"); got != "" {
+		t.Fatalf("unexpected decision header without valid secret: %s", got)
+	}
+}
@@ -4,6 +4,7 @@ package geoblock
 import (
 	"context"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -11,9 +12,11 @@ import (
 	"net/http"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/PascalMinder/geoblock/iptrie"
 	lru "github.com/PascalMinder/geoblock/lrucache"
 )
 
@@ -30,36 +33,94 @@ const (
 
 // Config the plugin configuration.
 type Config struct {
-	SilentStartUp                bool     `yaml:"silentStartUp"`
-	AllowLocalRequests           bool     `yaml:"allowLocalRequests"`
-	LogLocalRequests             bool     `yaml:"logLocalRequests"`
-	LogAllowedRequests           bool     `yaml:"logAllowedRequests"`
-	LogAPIRequests               bool     `yaml:"logApiRequests"`
-	API                          string   `yaml:"api"`
-	APITimeoutMs                 int      `yaml:"apiTimeoutMs"`
-	IgnoreAPITimeout             bool     `yaml:"ignoreApiTimeout"`
-	IgnoreAPIFailures            bool     `yaml:"ignoreApiFailures"`
-	IPGeolocationHTTPHeaderField string   `yaml:"ipGeolocationHttpHeaderField"`
-	XForwardedForReverseProxy    bool     `yaml:"xForwardedForReverseProxy"`
-	CacheSize                    int      `yaml:"cacheSize"`
-	CacheTTLSeconds              int      `yaml:"cacheTtlSeconds"`
-	ForceMonthlyUpdate           bool     `yaml:"forceMonthlyUpdate"`
-	AllowUnknownCountries        bool     `yaml:"allowUnknownCountries"`
-	UnknownCountryAPIResponse    string   `yaml:"unknownCountryApiResponse"`
-	BlackListMode                bool     `yaml:"blacklist"`
-	Countries                    []string `yaml:"countries,omitempty"`
-	AllowedIPAddresses           []string `yaml:"allowedIPAddresses,omitempty"`
-	AddCountryHeader             bool     `yaml:"addCountryHeader"`
-	HTTPStatusCodeDeniedRequest  int      `yaml:"httpStatusCodeDeniedRequest"`
-	RedirectURLIfDenied          string   `yaml:"redirectUrlIfDenied"`
-	ExcludedPathPatterns         []string `yaml:"excludedPathPatterns,omitempty"`
-	LogFilePath                  string   `yaml:"logFilePath"`
-	IPDatabaseCachePath          string   `yaml:"ipDatabaseCachePath"`
+	SilentStartUp                bool              `yaml:"silentStartUp"`
+	AllowLocalRequests           bool              `yaml:"allowLocalRequests"`
+	LogLocalRequests             bool              `yaml:"logLocalRequests"`
+	LogAllowedRequests           bool              `yaml:"logAllowedRequests"`
+	LogAPIRequests               bool              `yaml:"logApiRequests"`
+	API                          string            `yaml:"api"`
+	APITimeoutMs                 int               `yaml:"apiTimeoutMs"`
+	IgnoreAPITimeout             bool              `yaml:"ignoreApiTimeout"`
+	IgnoreAPIFailures            bool              `yaml:"ignoreApiFailures"`
+	IPGeolocationHTTPHeaderField string            `yaml:"ipGeolocationHttpHeaderField"`
+	XForwardedForReverseProxy    bool              `yaml:"xForwardedForReverseProxy"`
+	CacheSize                    int               `yaml:"cacheSize"`
+	CacheTTLSeconds              int               `yaml:"cacheTtlSeconds"`
+	ForceMonthlyUpdate           bool              `yaml:"forceMonthlyUpdate"`
+	AllowUnknownCountries        bool              `yaml:"allowUnknownCountries"`
+	UnknownCountryAPIResponse    string            `yaml:"unknownCountryApiResponse"`
+	BlackListMode                bool              `yaml:"blacklist"`
+	Countries                    []string          `yaml:"countries,omitempty"`
+	CountrySchedules             []CountrySchedule `yaml:"countrySchedules,omitempty"`
+	AllowedIPAddresses           []string          `yaml:"allowedIPAddresses,omitempty"`
+	DeniedIPAddresses            []string          `yaml:"deniedIPAddresses,omitempty"`
+	AllowedIPAddressesFile       string            `yaml:"allowedIPAddressesFile"`
+	DeniedIPAddressesFile        string            `yaml:"deniedIPAddressesFile"`
+	IPAddressesFilePollSeconds   int               `yaml:"ipAddressesFilePollSeconds"`
+	IPListSources                []IPListSource    `yaml:"ipListSources,omitempty"`
+	CountryOverrides             map[string]string `yaml:"countryOverrides,omitempty"`
+	AllowedASNs                  []string          `yaml:"allowedASNs,omitempty"`
+	DeniedASNs                   []string          `yaml:"deniedASNs,omitempty"`
+	ASNDatabasePath              string            `yaml:"asnDatabasePath"`
+	ASNAPI                       string            `yaml:"asnApi"`
+	ASNAPIField                  string            `yaml:"asnApiField"`
+	AddASNHeader                 bool              `yaml:"addAsnHeader"`
+	SubdivisionDatabasePath      string            `yaml:"subdivisionDatabasePath"`
+	SubdivisionAPI               string            `yaml:"subdivisionApi"`
+	SubdivisionAPIField          string            `yaml:"subdivisionApiField"`
+	AddRegionHeader              bool              `yaml:"addRegionHeader"`
+	AddCountryHeader             bool              `yaml:"addCountryHeader"`
+	SteeringMode                 bool              `yaml:"steeringMode"`
+	AddContinentHeader           bool              `yaml:"addContinentHeader"`
+	AddEUHeader                  bool              `yaml:"addEuHeader"`
+	CountryGroups                map[string]string `yaml:"countryGroups,omitempty"`
+	AddCountryGroupHeader        bool              `yaml:"addCountryGroupHeader"`
+	CountryPolicies              []CountryPolicy   `yaml:"countryPolicies,omitempty"`
+	RateLimitCacheSize           int               `yaml:"rateLimitCacheSize"`
+	TarpitMaxConnections         int               `yaml:"tarpitMaxConnections"`
+	BanThreshold                 int               `yaml:"banThreshold"`
+	BanWindowSeconds             int               `yaml:"banWindowSeconds"`
+	BanDurationSeconds           int               `yaml:"banDurationSeconds"`
+	BanLogEvery                  int               `yaml:"banLogEvery"`
+	BanListSize                  int               `yaml:"banListSize"`
+	BanListPath                  string            `yaml:"banListPath"`
+	HTTPStatusCodeDeniedRequest  int               `yaml:"httpStatusCodeDeniedRequest"`
+	DeniedResponseTemplate       string            `yaml:"deniedResponseTemplate"`
+	DeniedResponseTemplateFile   string            `yaml:"deniedResponseTemplateFile"`
+	DeniedResponseTemplates      map[string]string `yaml:"deniedResponseTemplates,omitempty"`
+	DeniedResponseTemplateFiles  map[string]string `yaml:"deniedResponseTemplateFiles,omitempty"`
+	RedirectURLIfDenied          string            `yaml:"redirectUrlIfDenied"`
+	RedirectURLsByCountry        map[string]string `yaml:"redirectUrlsByCountry,omitempty"`
+	RedirectStatusCode           int               `yaml:"redirectStatusCode"`
+	ExcludedPathPatterns         []string          `yaml:"excludedPathPatterns,omitempty"`
+	LogFilePath                  string            `yaml:"logFilePath"`
+	IPDatabaseCachePath          string            `yaml:"ipDatabaseCachePath"`
+	IPDatabaseCacheFormat        string            `yaml:"ipDatabaseCacheFormat"`
+	IPDatabaseCacheCompress      bool              `yaml:"ipDatabaseCacheCompress"`
+	IPDatabaseCacheWarmLoadLimit int               `yaml:"ipDatabaseCacheWarmLoadLimit"`
+	AdminAPIPath                 string            `yaml:"adminApiPath"`
+	AdminAPIToken                string            `yaml:"adminApiToken"`
+	AdminAPIAllowedIPs           []string          `yaml:"adminApiAllowedIPs,omitempty"`
+	ExplainSecret                string            `yaml:"explainSecret"`
+	BypassSecret                 string            `yaml:"bypassSecret"`
+	BypassHeader                 string            `yaml:"bypassHeader"`
+	BypassCookie                 string            `yaml:"bypassCookie"`
+	BypassTokenTTLSeconds        int               `yaml:"bypassTokenTtlSeconds"`
+	BypassIssuePath              string            `yaml:"bypassIssuePath"`
+	BypassIssueSecrets           []string          `yaml:"bypassIssueSecrets,omitempty"`
+	VerifiedBotDomains           []string          `yaml:"verifiedBotDomains,omitempty"`
+	VerifiedBotUserAgents        []string          `yaml:"verifiedBotUserAgents,omitempty"`
+	VerifiedBotCacheSize         int               `yaml:"verifiedBotCacheSize"`
+	VerifiedBotCacheTTLSeconds   int               `yaml:"verifiedBotCacheTtlSeconds"`
+	VerifiedBotTimeoutMs         int               `yaml:"verifiedBotTimeoutMs"`
 }
 
 type ipEntry struct {
-	Country   string
-	Timestamp time.Time
+	Country     string
+	ASN         uint32 // 0 if unknown or no ASN source is configured
+	Subdivision string // ISO 3166-2 code, e.g. "US-CA"; empty if unknown
+	Timestamp   time.Time
+	Pinned      bool // set through the admin API; never refreshed or expired
 }
 
 // CreateConfig creates the default plugin configuration.
@@ -87,18 +148,43 @@ type GeoBlock struct {
 	unknownCountryCode           string
 	blackListMode                bool
 	countries                    []string
-	allowedIPAddresses           []net.IP
-	allowedIPRanges              []*net.IPNet
-	privateIPRanges              []*net.IPNet
+	schedules                    []*countrySchedule
+	clock                        func() time.Time
+	allowedIPs                   *ipList
+	deniedIPs                    *ipList
+	countryOverrides             *iptrie.Trie
+	overrideASNs                 *lru.LRUCache // ASNs of the overridden IP addresses, nil without ASN source
+	privateIPRanges              *iptrie.Trie
+	asnResolver                  asnResolver
+	allowedASNs                  map[uint32]bool
+	deniedASNs                   map[uint32]bool
+	subdivisionResolver          subdivisionResolver
 	addCountryHeader             bool
+	addASNHeader                 bool
+	addRegionHeader              bool
+	steeringMode                 bool
+	addContinentHeader           bool
+	addEUHeader                  bool
+	countryGroups                map[string]string
+	addCountryGroupHeader        bool
+	policies                     *countryPolicies
+	bans                         *banList
+	verifiedBots                 *verifiedBots
+	tarpit                       *tarpit
 	httpStatusCodeDeniedRequest  int
+	deniedResponse               *deniedResponse
 	database                     *lru.LRUCache
 	logFile                      *os.File
 	redirectURLIfDenied          string
+	redirectURLsByCountry        map[string]string
+	redirectStatusCode           int
 	excludedPathRegexps          []*regexp.Regexp
 	name                         string
 	infoLogger                   *log.Logger
 	ipDatabasePersistence        *CachePersist
+	adminAPI                     *adminAPI
+	explainSecret                string
+	bypass                       *bypass
 }
 
 // New created a new GeoBlock plugin.
@@ -114,13 +200,31 @@ func New(ctx context.Context, next http.Handler, config *Config, name string) (h
 		return nil, err
 	}
 
-	allowedIPAddresses, allowedIPRanges := parseAllowedIPAddresses(config.AllowedIPAddresses, infoLogger)
+	allowedIPRanges, err := parseIPNets(config.AllowedIPAddresses)
+	if err != nil {
+		return nil, fmt.Errorf("allowed IP addresses: %w", err)
+	}
+
+	deniedIPRanges, err := parseIPNets(config.DeniedIPAddresses)
+	if err != nil {
+		return nil, fmt.Errorf("denied IP addresses: %w", err)
+	}
+
+	rules, err := buildEntryRules(config, name)
+	if err != nil {
+		return nil, err
+	}
 
 	excludedPathRegexps, err := compileExcludedPathPatterns(config.ExcludedPathPatterns)
 	if err != nil {
 		return nil, err
 	}
 
+	deniedResponse, err := buildDeniedResponse(config, infoLogger, name)
+	if err != nil {
+		return nil, err
+	}
+
 	infoLogger.SetOutput(os.Stdout)
 	if !config.SilentStartUp {
 		infoLogger.Printf("%s: Starting middleware", name)
@@ -132,27 +236,205 @@ func New(ctx context.Context, next http.Handler, config *Config, name string) (h
 		return nil, err
 	}
 
+	allowedIPs, deniedIPs, err := buildIPLists(ctx, config, allowedIPRanges, deniedIPRanges, infoLogger, name)
+	if err != nil {
+		return nil, err
+	}
+
 	cache, ipDB, err := buildCache(config, infoLogger, name)
 	if err != nil {
 		return nil, err
 	}
 
+	adminAPI, err := buildAdminAPI(config, cache, ipDB, infoLogger, name)
+	if err != nil {
+		return nil, err
+	}
+
+	bans, err := buildBanList(config, infoLogger, name)
+	if err != nil {
+		return nil, err
+	}
+
+	verifiedBots, err := buildVerifiedBots(config)
+	if err != nil {
+		return nil, err
+	}
+
 	return buildGeoBlock(
-		next, config, name, infoLogger, logFile, cache, ipDB,
-		allowedIPAddresses, allowedIPRanges, excludedPathRegexps,
+		next, config, name, infoLogger, logFile, cache, ipDB, adminAPI,
+		allowedIPs, deniedIPs, rules, bans, verifiedBots, deniedResponse, excludedPathRegexps,
 	), nil
 }
 
+// entryRules are the rules evaluated on the looked up entry of an IP address.
+type entryRules struct {
+	countryOverrides *iptrie.Trie
+	overrideASNs     *lru.LRUCache
+	asns             *asnRules
+	subdivisions     subdivisionResolver
+	schedules        []*countrySchedule
+	policies         *countryPolicies
+}
+
+func buildEntryRules(config *Config, name string) (*entryRules, error) {
+	countryOverrides, err := parseCountryOverrides(config.CountryOverrides)
+	if err != nil {
+		return nil, err
+	}
+
+	asns, err := buildASNRules(config)
+	if err != nil {
+		return nil, err
+	}
+
+	subdivisions, err := buildSubdivisionResolver(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var overrideASNs *lru.LRUCache
+	if len(config.CountryOverrides) > 0 && asns.resolver != nil {
+		if overrideASNs, err = lru.NewLRUCache(config.CacheSize); err != nil {
+			return nil, fmt.Errorf("country overrides: %w", err)
+		}
+	}
+
+	schedules, err := buildCountrySchedules(config)
+	if err != nil {
+		return nil, err
+	}
+
+	policies, err := buildCountryPolicies(config, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &entryRules{
+		countryOverrides: countryOverrides,
+		overrideASNs:     overrideASNs,
+		asns:             asns,
+		subdivisions:     subdivisions,
+		schedules:        schedules,
+		policies:         policies,
+	}, nil
+}
+
+// buildIPLists builds the allowed and denied IP address lists from the
+// configured ranges, the files and the remote sources.
+func buildIPLists(
+	ctx context.Context, config *Config, allowedIPRanges, deniedIPRanges []*net.IPNet, logger *log.Logger, name string,
+) (*ipList, *ipList, error) {
+	allowedIPs, err := buildIPList(ctx, "allowed", allowedIPRanges, config.AllowedIPAddressesFile, config, logger, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	deniedIPs, err := buildIPList(ctx, "denied", deniedIPRanges, config.DeniedIPAddressesFile, config, logger, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := startIPListSources(config, allowedIPs, deniedIPs, logger, name); err != nil {
+		return nil, nil, err
+	}
+
+	return allowedIPs, deniedIPs, nil
+}
+
+// validateConfig checks the whole configuration and returns every problem
+// found as one aggregated error, so a typo disables only this middleware
+// instead of taking down the Traefik process.
 func validateConfig(config *Config) error {
+	var errs []error
+
 	if len(config.API) == 0 || !strings.Contains(config.API, "{ip}") {
-		return fmt.Errorf("no api uri given")
+		errs = append(errs, fmt.Errorf("no api uri given"))
 	}
 
-	if len(config.Countries) == 0 {
-		return fmt.Errorf("no allowed country code provided")
+	// in steering mode no request is denied, the countries are optional
+	if len(config.Countries) == 0 && len(config.CountrySchedules) == 0 && !config.SteeringMode {
+		errs = append(errs, fmt.Errorf("no allowed country code provided"))
+	}
+	for _, country := range config.Countries {
+		if !isCountryCode(country) && !isSubdivisionCode(country) {
+			errs = append(errs, fmt.Errorf("invalid country code [%s]", country))
+		}
 	}
 
-	return nil
+	if _, err := getHTTPStatusCodeDeniedRequest(config.HTTPStatusCodeDeniedRequest); err != nil {
+		errs = append(errs, err)
+	}
+
+	for _, pattern := range config.ExcludedPathPatterns {
+		if _, err := compileExcludedPathPatterns([]string{pattern}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	errs = append(errs, validateIPConfig(config)...)
+	errs = append(errs, validateASNConfig(config)...)
+	errs = append(errs, validateSubdivisionConfig(config)...)
+	errs = append(errs, validatePathConfig(config)...)
+	errs = append(errs, validateDeniedResponseConfig(config)...)
+	errs = append(errs, validateRedirectConfig(config)...)
+	errs = append(errs, validateSteeringConfig(config)...)
+	errs = append(errs, validateScheduleConfig(config)...)
+	errs = append(errs, validateCountryPolicies(config)...)
+	errs = append(errs, validateTarpitConfig(config)...)
+	errs = append(errs, validateBanConfig(config)...)
+	errs = append(errs, validateBypassConfig(config)...)
+	errs = append(errs, validateVerifiedBotConfig(config)...)
+
+	return errors.Join(errs...)
+}
+
+func validateIPConfig(config *Config) []error {
+	var errs []error
+
+	for _, entry := range config.AllowedIPAddresses {
+		if _, err := parseIPNets([]string{entry}); err != nil {
+			errs = append(errs, fmt.Errorf("allowed IP addresses: %w", err))
+		}
+	}
+
+	for _, entry := range config.DeniedIPAddresses {
+		if _, err := parseIPNets([]string{entry}); err != nil {
+			errs = append(errs, fmt.Errorf("denied IP addresses: %w", err))
+		}
+	}
+
+	for entry, country := range config.CountryOverrides {
+		if _, err := parseCountryOverrides(map[string]string{entry: country}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, source := range config.IPListSources {
+		if err := validateIPListSource(source); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
+
+func validatePathConfig(config *Config) []error {
+	var errs []error
+
+	if _, err := ValidatePersistencePath(config.LogFilePath); err != nil {
+		errs = append(errs, fmt.Errorf("log file path: %w", err))
+	}
+
+	if _, err := ValidatePersistencePath(config.IPDatabaseCachePath); err != nil {
+		errs = append(errs, fmt.Errorf("ip database cache path: %w", err))
+	}
+
+	if _, err := resolveCacheFormat(config.IPDatabaseCachePath, config.IPDatabaseCacheFormat); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errs
 }
 
 func applyDefaults(config *Config) error {
@@ -166,6 +448,12 @@ func applyDefaults(config *Config) error {
 	}
 	config.HTTPStatusCodeDeniedRequest = deniedRequestHTTPStatusCode
 
+	redirectStatusCode, err := getRedirectStatusCode(config.RedirectStatusCode)
+	if err != nil {
+		return err
+	}
+	config.RedirectStatusCode = redirectStatusCode
+
 	return nil
 }
 
@@ -186,10 +474,33 @@ func buildLogTarget(ctx context.Context, config *Config, logger *log.Logger, nam
 	return logTarget, nil
 }
 
+func buildIPList(
+	ctx context.Context, kind string, ipNets []*net.IPNet, path string, config *Config, logger *log.Logger, name string,
+) (*ipList, error) {
+	list := newIPList(kind, ipNets, logger, name)
+	if len(path) == 0 {
+		return list, nil
+	}
+
+	interval := defaultIPListFilePollInterval
+	if config.IPAddressesFilePollSeconds > 0 {
+		interval = time.Duration(config.IPAddressesFilePollSeconds) * time.Second
+	}
+
+	if err := list.watchFile(ctx, path, interval); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
 func buildCache(config *Config, logger *log.Logger, name string) (*lru.LRUCache, *CachePersist, error) {
 	cacheOptions := Options{
 		CacheSize:       config.CacheSize,
 		CachePath:       config.IPDatabaseCachePath,
+		CacheFormat:     config.IPDatabaseCacheFormat,
+		CacheCompress:   config.IPDatabaseCacheCompress,
+		CacheTTL:        effectiveCacheTTL(time.Duration(config.CacheTTLSeconds)*time.Second, config.ForceMonthlyUpdate),
+		WarmLoadLimit:   config.IPDatabaseCacheWarmLoadLimit,
 		PersistInterval: defaultCacheWriteCycle,
 		Logger:          logger,
 		SilentStartUp:   config.SilentStartUp,
@@ -208,8 +519,13 @@ func buildGeoBlock(
 	logFile *os.File,
 	cache *lru.LRUCache,
 	ipDB *CachePersist,
-	allowedIPAddresses []net.IP,
-	allowedIPRanges []*net.IPNet,
+	adminAPI *adminAPI,
+	allowedIPs *ipList,
+	deniedIPs *ipList,
+	rules *entryRules,
+	bans *banList,
+	verifiedBots *verifiedBots,
+	deniedResponse *deniedResponse,
 	excludedPathRegexps []*regexp.Regexp,
 ) *GeoBlock {
 	return &GeoBlock{
@@ -231,63 +547,177 @@ func buildGeoBlock(
 		unknownCountryCode:           config.UnknownCountryAPIResponse,
 		blackListMode:                config.BlackListMode,
 		countries:                    config.Countries,
-		allowedIPAddresses:           allowedIPAddresses,
-		allowedIPRanges:              allowedIPRanges,
-		privateIPRanges:              initPrivateIPBlocks(),
+		schedules:                    rules.schedules,
+		clock:                        time.Now,
+		allowedIPs:                   allowedIPs,
+		deniedIPs:                    deniedIPs,
+		countryOverrides:             rules.countryOverrides,
+		overrideASNs:                 rules.overrideASNs,
+		privateIPRanges:              newIPTrie(initPrivateIPBlocks()),
+		asnResolver:                  rules.asns.resolver,
+		allowedASNs:                  rules.asns.allowed,
+		deniedASNs:                   rules.asns.denied,
+		subdivisionResolver:          rules.subdivisions,
 		database:                     cache,
-		addCountryHeader:             config.AddCountryHeader,
+		addCountryHeader:             config.AddCountryHeader || config.SteeringMode,
+		addASNHeader:                 config.AddASNHeader,
+		addRegionHeader:              config.AddRegionHeader,
+		steeringMode:                 config.SteeringMode,
+		addContinentHeader:           config.AddContinentHeader,
+		addEUHeader:                  config.AddEUHeader,
+		countryGroups:                normalizeCountryGroups(config.CountryGroups),
+		addCountryGroupHeader:        config.AddCountryGroupHeader,
+		policies:                     rules.policies,
+		bans:                         bans,
+		verifiedBots:                 verifiedBots,
+		tarpit:                       buildTarpit(config, name),
 		httpStatusCodeDeniedRequest:  config.HTTPStatusCodeDeniedRequest,
+		deniedResponse:               deniedResponse,
 		logFile:                      logFile,
 		redirectURLIfDenied:          config.RedirectURLIfDenied,
+		redirectURLsByCountry:        normalizeRedirectURLsByCountry(config.RedirectURLsByCountry),
+		redirectStatusCode:           config.RedirectStatusCode,
 		excludedPathRegexps:          excludedPathRegexps,
 		name:                         name,
 		infoLogger:                   logger,
 		ipDatabasePersistence:        ipDB, // may be nil => feature OFF
+		adminAPI:                     adminAPI,
+		explainSecret:                config.ExplainSecret,
+		bypass:                       buildBypass(config, logger, name),
 	}
 }
 
 func (a *GeoBlock) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if a.adminAPI != nil && req.URL.Path == a.adminAPI.path {
+		a.adminAPI.ServeHTTP(rw, req)
+		return
+	}
+
+	// banned clients are answered before any other processing
+	if a.rejectBanned(rw, req) {
+		return
+	}
+
+	req, served := a.handleBypass(rw, req)
+	if served {
+		return
+	}
+
+	trace, req := a.startExplain(req)
+	if trace != nil {
+		// never forward the explain secret to the service
+		req.Header.Del(explainHeader)
+	}
+
 	fullURL := req.Host + req.URL.Path
 	if a.isPathExcluded(fullURL) {
 		if a.logAllowedRequests {
 			a.infoLogger.Printf("%s: request allowed for [%s] due to excluded path pattern", a.name, fullURL)
 		}
+		trace.finish(true, reasonExcludedPath)
+		if trace.write(rw) {
+			return
+		}
 		a.next.ServeHTTP(rw, req)
 		return
 	}
 
-	requestIPAddresses, err := a.collectRemoteIP(req)
+	if a.steeringMode {
+		a.steer(rw, req, trace)
+		return
+	}
+
+	result, err := a.evaluate(req, trace)
 	if err != nil {
 		// if one of the ip addresses could not be parsed, return status forbidden
 		a.infoLogger.Printf("%s: %s", a.name, err)
-		rw.WriteHeader(http.StatusForbidden)
+		trace.finish(false, reasonInvalidIP)
+		if trace.write(rw) {
+			return
+		}
+		a.writeDeniedResponse(rw, req, http.StatusForbidden, decision{reason: reasonInvalidIP})
+		return
+	}
+
+	trace.finish(result.allowed, trace.finalReason())
+	if trace.write(rw) {
 		return
 	}
 
+	if !result.allowed {
+		a.deny(rw, req, result)
+		return
+	}
+
+	a.next.ServeHTTP(rw, req)
+}
+
+// evaluate checks the client IP addresses of the request and returns the
+// decision on the first denied one, or an allowed decision if none is denied.
+func (a *GeoBlock) evaluate(req *http.Request, trace *explainTrace) (decision, error) {
+	requestIPAddresses, err := a.collectRemoteIP(req)
+	if err != nil {
+		return decision{}, err
+	}
+
 	if a.logAllowedRequests {
-		a.infoLogger.Printf("%s: evaluating client IP(s) [%s] for [%s]", a.name, formatIPList(requestIPAddresses), fullURL)
+		a.infoLogger.Printf("%s: evaluating client IP(s) [%s] for [%s]",
+			a.name, formatIPList(requestIPAddresses), req.Host+req.URL.Path)
 	}
 
 	// Only keep the first IP address (should be the client, if the proxy behaves itself)
 	// so we can check whether it is allowed or denied.
+	collectedIPAddresses := requestIPAddresses
 	if a.xForwardedForReverseProxy {
 		requestIPAddresses = requestIPAddresses[:1]
 	}
+	trace.collected(collectedIPAddresses, requestIPAddresses)
 
+	result := decision{allowed: true}
 	for _, requestIPAddress := range requestIPAddresses {
-		if !a.allowDenyIPAddress(requestIPAddress, req) {
-			if len(a.redirectURLIfDenied) != 0 {
-				rw.Header().Set("Location", a.redirectURLIfDenied)
-				rw.WriteHeader(http.StatusFound)
-				return
-			}
-
-			rw.WriteHeader(a.httpStatusCodeDeniedRequest)
-			return
+		trace.beginIP(requestIPAddress)
+		result = a.allowDenyIPAddress(requestIPAddress, req)
+		result.ip = *requestIPAddress
+		trace.decide(result.allowed, result.reason)
+		if !result.allowed {
+			break
 		}
 	}
+	return result, nil
+}
 
-	a.next.ServeHTTP(rw, req)
+// deny answers a denied request: with 429 if rate limited, else after the
+// tarpit delay, if any, with a redirect or the denied response.
+func (a *GeoBlock) deny(rw http.ResponseWriter, req *http.Request, result decision) {
+	if result.reason == reasonRateLimited {
+		a.writeRateLimited(rw, req, result)
+		return
+	}
+	if countsTowardBan(result.reason) {
+		a.bans.recordDenial(result.ip)
+	}
+
+	if result.delay > 0 && !a.tarpit.hold(req.Context(), result.delay) {
+		return
+	}
+
+	if location, ok := a.redirectTarget(req, result); ok {
+		rw.Header().Set("Location", location)
+		rw.WriteHeader(a.redirectStatusCode)
+		return
+	}
+
+	a.writeDeniedResponse(rw, req, a.httpStatusCodeDeniedRequest, result)
+}
+
+// decision is the outcome of the checks for an IP address.
+type decision struct {
+	allowed    bool
+	reason     string
+	ip         net.IP
+	entry      ipEntry       // the looked up country, ASN and subdivision, if any
+	retryAfter time.Duration // until the rate limit accepts the next request
+	delay      time.Duration // tarpit delay before the denied response
 }
 
 func (a *GeoBlock) isPathExcluded(path string) bool {
@@ -299,61 +729,60 @@ func (a *GeoBlock) isPathExcluded(path string) bool {
 	return false
 }
 
-func (a *GeoBlock) allowDenyIPAddress(requestIPAddr *net.IP, req *http.Request) bool {
-	// check if the request IP address is explicitly allowed
-	if ipInSlice(*requestIPAddr, a.allowedIPAddresses) {
-		if a.addCountryHeader {
-			ok, countryCode := a.cachedRequestIP(requestIPAddr, req)
-			if ok && len(countryCode) > 0 {
-				req.Header.Set(countryHeader, countryCode)
+func (a *GeoBlock) allowDenyIPAddress(requestIPAddr *net.IP, req *http.Request) decision {
+	trace := explainFrom(req)
+
+	// The checks are evaluated in order of precedence: denied IP addresses,
+	// allowed IP addresses, local IP addresses, the ASN rules, the country
+	// policies and finally the country rules, which bypass tokens and
+	// verified bots pass.
+
+	// check if the request IP address is contained within one of the explicitly denied IP address ranges
+	if a.deniedIPs.Contains(*requestIPAddr) {
+		a.infoLogger.Printf("%s: request denied [%s] due to: %s", a.name, requestIPAddr, reasonDeniedIP)
+		trace.explicitlyDenied()
+		return decision{allowed: false, reason: reasonDeniedIP}
+	}
+
+	// check if the request IP address is explicitly allowed or contained within one of the
+	// explicitly allowed IP address ranges
+	if a.allowedIPs.Contains(*requestIPAddr) {
+		if a.addsEntryHeaders() {
+			if ok, entry := a.cachedRequestIP(requestIPAddr, req); ok {
+				a.addEntryHeaders(req, entry)
 			}
 		}
 		if a.logAllowedRequests {
 			a.infoLogger.Printf("%s: request allowed [%s] since the IP address is explicitly allowed", a.name, requestIPAddr)
 		}
-		return true
-	}
-
-	// check if the request IP address is contained within one of the explicitly allowed IP address ranges
-	for _, ipRange := range a.allowedIPRanges {
-		if ipRange.Contains(*requestIPAddr) {
-			if a.addCountryHeader {
-				ok, countryCode := a.cachedRequestIP(requestIPAddr, req)
-				if ok && len(countryCode) > 0 {
-					req.Header.Set(countryHeader, countryCode)
-				}
-			}
-			if a.logAllowedRequests {
-				a.infoLogger.Printf("%s: request allowed [%s] since the IP address is explicitly allowed", a.name, requestIPAddr)
-			}
-			return true
-		}
+		trace.explicitlyAllowed()
+		return decision{allowed: true, reason: reasonAllowedIP}
 	}
 
 	// check if the request IP address is a local address and if those are allowed
 	if isPrivateIP(*requestIPAddr, a.privateIPRanges) {
+		trace.local()
 		if a.allowLocalRequests {
 			if a.logLocalRequests {
 				a.infoLogger.Printf("%s: request allowed [%s] since local IP addresses are allowed", a.name, requestIPAddr)
 			}
-			return true
+			return decision{allowed: true, reason: reasonLocalAllowed}
 		}
 
 		// Always surface local denials: this is the most common cause of an
 		// unexplained 403 (the evaluated IP is a proxy/private address), so the
 		// reason must be visible even when logLocalRequests is off.
 		a.infoLogger.Printf("%s: request denied [%s] since local IP addresses are denied", a.name, requestIPAddr)
-		return false
+		return decision{allowed: false, reason: reasonLocalDenied}
 	}
 
 	// check if the GeoIP database contains an entry for the request IP address
-	allowed, countryCode := a.allowDenyCachedRequestIP(requestIPAddr, req)
-
-	if a.addCountryHeader && len(countryCode) > 0 {
-		req.Header.Set(countryHeader, countryCode)
-	}
+	result := a.allowDenyCachedRequestIP(requestIPAddr, req)
+	result = a.allowBypassToken(requestIPAddr, req, result)
+	result = a.allowVerifiedBot(requestIPAddr, req, result)
+	a.addEntryHeaders(req, result.entry)
 
-	return allowed
+	return result
 }
 
 // shouldRefreshEntry reports whether a cached entry has outlived its TTL and
@@ -361,20 +790,66 @@ func (a *GeoBlock) allowDenyIPAddress(requestIPAddr *net.IP, req *http.Request)
 //
 // An explicit cacheTtlSeconds takes effect on its own. When it is unset (<= 0),
 // forceMonthlyUpdate preserves the legacy behavior of refreshing entries once
-// they are ~30 days old otherwise entries never expire by age.
+// they are ~30 days old otherwise entries never expire by age. Entries pinned
+// through the admin API never expire.
 func (a *GeoBlock) shouldRefreshEntry(entry ipEntry) bool {
-	ttl := a.cacheTTL
-	if ttl <= 0 {
-		if !a.forceMonthlyUpdate {
-			return false
-		}
-		ttl = defaultCacheTTL
+	ttl := effectiveCacheTTL(a.cacheTTL, a.forceMonthlyUpdate)
+	if ttl <= 0 || entry.Pinned {
+		return false
 	}
 
 	return time.Since(entry.Timestamp) >= ttl
 }
 
-func (a *GeoBlock) allowDenyCachedRequestIP(requestIPAddr *net.IP, req *http.Request) (bool, string) {
+// effectiveCacheTTL resolves cacheTtlSeconds and the legacy forceMonthlyUpdate
+// flag into a single TTL; 0 means entries never expire by age.
+func effectiveCacheTTL(ttl time.Duration, forceMonthlyUpdate bool) time.Duration {
+	if ttl > 0 {
+		return ttl
+	}
+	if forceMonthlyUpdate {
+		return defaultCacheTTL
+	}
+	return 0
+}
+
+// addsEntryHeaders reports whether any header of the IP entry is configured.
+func (a *GeoBlock) addsEntryHeaders() bool {
+	return a.addCountryHeader || a.addASNHeader || a.addRegionHeader ||
+		a.addContinentHeader || a.addEUHeader || a.addCountryGroupHeader
+}
+
+// addEntryHeaders adds the configured country, ASN, region and derived
+// request headers.
+func (a *GeoBlock) addEntryHeaders(req *http.Request, entry ipEntry) {
+	if a.addCountryHeader && len(entry.Country) > 0 {
+		req.Header.Set(countryHeader, entry.Country)
+	}
+	if a.addASNHeader && entry.ASN != 0 {
+		req.Header.Set(asnHeader, strconv.FormatUint(uint64(entry.ASN), 10))
+	}
+	if a.addRegionHeader && len(entry.Subdivision) > 0 {
+		req.Header.Set(regionHeader, entry.Subdivision)
+	}
+	a.addDerivedHeaders(req, entry)
+}
+
+func (a *GeoBlock) allowDenyCachedRequestIP(requestIPAddr *net.IP, req *http.Request) decision {
+	trace := explainFrom(req)
+
+	// an override is the IP address' true country, no lookup needed
+	if country, ok := a.countryOverride(*requestIPAddr); ok {
+		trace.lookup(sourceOverride, country)
+		if a.logAPIRequests {
+			a.infoLogger.Printf("%s: [%s] country [%s] set by override", a.name, requestIPAddr, country)
+		}
+
+		entry := a.overrideEntry(*requestIPAddr, country)
+		result := a.allowDenyEntry(requestIPAddr, entry)
+		trace.details(entry)
+		return result
+	}
+
 	ipAddressString := requestIPAddr.String()
 	cacheEntry, cacheHit := a.database.Get(ipAddressString)
 
@@ -385,82 +860,111 @@ func (a *GeoBlock) allowDenyCachedRequestIP(requestIPAddr *net.IP, req *http.Req
 		if err != nil {
 			if a.ignoreAPIFailures {
 				a.infoLogger.Printf("%s: request allowed [%s] due to API failure", a.name, requestIPAddr)
-				return true, ""
+				return decision{allowed: true, reason: reasonAPIFailureIgnored}
 			}
 
 			if os.IsTimeout(err) && a.ignoreAPITimeout {
 				a.infoLogger.Printf("%s: request allowed [%s] due to API timeout", a.name, requestIPAddr)
 				// TODO: this was previously an immediate response to the client
-				return true, ""
+				return decision{allowed: true, reason: reasonAPITimeoutIgnored}
 			}
 
 			a.infoLogger.Printf("%s: request denied [%s] due to error: %s", a.name, requestIPAddr, err)
-			return false, ""
+			return decision{allowed: false, reason: reasonLookupFailed}
 		}
 	} else {
-		entry = cacheEntry.(ipEntry)
+		entry = a.backfillEntry(ipAddressString, cacheEntry.(ipEntry))
+		trace.cacheHit(entry)
 		// order has changed
 		a.ipDatabasePersistence.MarkDirty()
 	}
 
 	if a.logAPIRequests {
-		a.infoLogger.Printf("%s: [%s] loaded from database: %s", a.name, requestIPAddr, entry)
+		a.infoLogger.Printf("%s: [%s] loaded from database: %v", a.name, requestIPAddr, entry)
 	}
 
 	// check if existing entry is older than the configured cache TTL, if so update the entry
 	if a.shouldRefreshEntry(entry) {
+		trace.refreshed()
 		entry, err = a.createNewIPEntry(req, ipAddressString)
 		if err != nil {
 			if a.ignoreAPIFailures {
 				a.infoLogger.Printf("%s: request allowed [%s] due to API failure", a.name, requestIPAddr)
-				return true, ""
+				return decision{allowed: true, reason: reasonAPIFailureIgnored}
 			}
 			a.infoLogger.Printf("%s: request denied [%s] due to error: %s", a.name, requestIPAddr, err)
-			return false, ""
+			return decision{allowed: false, reason: reasonLookupFailed}
 		}
 	}
 
+	result := a.allowDenyEntry(requestIPAddr, entry)
+	trace.details(entry)
+
+	return result
+}
+
+// allowDenyCountry decides on the country and, if known, the subdivision of
+// a request IP address and returns the reason code of the decision. The
+// countries list may contain both, e.g. "CH" and "US-CA".
+func (a *GeoBlock) allowDenyCountry(requestIPAddr *net.IP, country, subdivision string) (bool, string) {
 	// check if we are in black/white-list mode and allow/deny based on country code.
 	// Note: allowUnknownCountries only has an effect in whitelist mode. In blacklist
 	// mode an unknown country is, by definition, not on the blocklist, so
 	// isCountryAllowed is already true and the allowUnknownCountries term is redundant.
-	isUnknownCountry := entry.Country == unknownCountryCode
-	isCountryAllowed := stringInSlice(entry.Country, a.countries) != a.blackListMode
+	isUnknownCountry := country == unknownCountryCode
+	isListed := stringInSlice(country, a.countries) || (len(subdivision) > 0 && stringInSlice(subdivision, a.countries)) ||
+		a.scheduledCountry(country, subdivision)
+	isCountryAllowed := isListed != a.blackListMode
+
+	// log the most specific location
+	if len(subdivision) > 0 {
+		country = subdivision
+	}
 	isAllowed := isCountryAllowed || (isUnknownCountry && a.allowUnknownCountries)
 
 	if !isAllowed {
+		reason := reasonCountryNotAllowed
 		switch {
 		case isUnknownCountry && !a.allowUnknownCountries:
+			reason = reasonUnknownCountry
 			a.infoLogger.Printf(
 				"%s: request denied [%s] for country [%s] due to: unknown country",
 				a.name,
 				requestIPAddr,
-				entry.Country)
+				country)
 		case !isCountryAllowed:
 			a.infoLogger.Printf(
 				"%s: request denied [%s] for country [%s] due to: country is not allowed",
 				a.name,
 				requestIPAddr,
-				entry.Country)
+				country)
 		default:
 			a.infoLogger.Printf(
 				"%s: request denied [%s] for country [%s]",
 				a.name,
 				requestIPAddr,
-				entry.Country)
+				country)
 		}
 
-		return false, entry.Country
+		return false, reason
 	}
 
 	if a.logAllowedRequests {
-		a.infoLogger.Printf("%s: request allowed [%s] for country [%s]", a.name, requestIPAddr, entry.Country)
+		a.infoLogger.Printf("%s: request allowed [%s] for country [%s]", a.name, requestIPAddr, country)
 	}
 
-	return true, entry.Country
+	if !isCountryAllowed {
+		return true, reasonUnknownCountryAllowed
+	}
+	return true, reasonCountryAllowed
 }
 
-func (a *GeoBlock) cachedRequestIP(requestIPAddr *net.IP, req *http.Request) (bool, string) {
+func (a *GeoBlock) cachedRequestIP(requestIPAddr *net.IP, req *http.Request) (bool, ipEntry) {
+	if country, ok := a.countryOverride(*requestIPAddr); ok {
+		explainFrom(req).lookup(sourceOverride, country)
+		return true, a.overrideEntry(*requestIPAddr, country)
+	}
+
 	ipAddressString := requestIPAddr.String()
 	cacheEntry, ok := a.database.Get(ipAddressString)
 
@@ -469,27 +973,29 @@ func (a *GeoBlock) cachedRequestIP(requestIPAddr *net.IP, req *http.Request) (bo
 	if !ok {
 		entry, err = a.createNewIPEntry(req, ipAddressString)
 		if err != nil {
-			return false, ""
+			return false, ipEntry{}
 		}
 	} else {
-		entry = cacheEntry.(ipEntry)
+		entry = a.backfillEntry(ipAddressString, cacheEntry.(ipEntry))
+		explainFrom(req).cacheHit(entry)
 		// order has changed
 		a.ipDatabasePersistence.MarkDirty()
 	}
 
 	if a.logAPIRequests {
-		a.infoLogger.Printf("%s: [%s] Loaded from database: %s", a.name, ipAddressString, entry)
+		a.infoLogger.Printf("%s: [%s] Loaded from database: %v", a.name, ipAddressString, entry)
 	}
 
 	// check if existing entry is older than the configured cache TTL, if so update the entry
 	if a.shouldRefreshEntry(entry) {
+		explainFrom(req).refreshed()
 		entry, err = a.createNewIPEntry(req, ipAddressString)
 		if err != nil {
-			return false, ""
+			return false, ipEntry{}
 		}
 	}
 
-	return true, entry.Country
+	return true, entry
 }
 
 func (a *GeoBlock) collectRemoteIP(req *http.Request) ([]*net.IP, error) {
@@ -536,21 +1042,78 @@ func (a *GeoBlock) createNewIPEntry(req *http.Request, ipAddressString string) (
 		return entry, err
 	}
 
-	entry = ipEntry{Country: country, Timestamp: time.Now()}
+	ip := net.ParseIP(ipAddressString)
+	entry = ipEntry{
+		Country:     country,
+		ASN:         a.lookupASN(ip),
+		Subdivision: a.lookupSubdivision(ip, country),
+		Timestamp:   time.Now(),
+	}
 	a.database.Add(ipAddressString, entry)
 	a.ipDatabasePersistence.MarkDirty() // new entry in the cache
 
 	if a.logAPIRequests {
-		a.infoLogger.Printf("%s: [%s] added to database: %s", a.name, ipAddressString, entry)
+		a.infoLogger.Printf("%s: [%s] added to database: %v", a.name, ipAddressString, entry)
 	}
 
 	return entry, nil
 }
 
+// overrideEntry returns the entry for an IP address with a country override.
+// The subdivision of a lookup would belong to the looked up country, so the
+// entry has none. The entry is not written to the shared cache, which other
+// middlewares without the override use as well; only its ASN is kept in a
+// cache of this middleware, so the ASN is not resolved on every request.
+func (a *GeoBlock) overrideEntry(ip net.IP, country string) ipEntry {
+	entry := ipEntry{Country: country}
+	if a.overrideASNs == nil {
+		return entry
+	}
+
+	ipAddressString := ip.String()
+	if cacheEntry, ok := a.overrideASNs.Get(ipAddressString); ok {
+		if asnEntry := cacheEntry.(ipEntry); !a.shouldRefreshEntry(asnEntry) {
+			entry.ASN = asnEntry.ASN
+			return entry
+		}
+	}
+
+	entry.ASN = a.lookupASN(ip)
+	a.overrideASNs.Add(ipAddressString, ipEntry{ASN: entry.ASN, Timestamp: time.Now()})
+	return entry
+}
+
+// backfillEntry resolves the ASN and subdivision of a cache entry without
+// one, e.g. written before the source was configured or after a failed
+// lookup. Many IP addresses have no ASN or subdivision, so only the offline
+// databases are cheap enough to be queried again on every cache hit; an
+// entry of an online source is refreshed with the cache TTL.
+func (a *GeoBlock) backfillEntry(ipAddressString string, entry ipEntry) ipEntry {
+	ip := net.ParseIP(ipAddressString)
+	updated := false
+
+	if _, online := a.asnResolver.(*asnAPI); a.asnResolver != nil && !online && entry.ASN == 0 {
+		entry.ASN = a.lookupASN(ip)
+		updated = entry.ASN != 0
+	}
+
+	if _, ok := a.subdivisionResolver.(*mmdbSubdivisionDatabase); ok && len(entry.Subdivision) == 0 {
+		entry.Subdivision = a.lookupSubdivision(ip, entry.Country)
+		updated = updated || len(entry.Subdivision) > 0
+	}
+
+	if updated {
+		a.database.Add(ipAddressString, entry)
+		a.ipDatabasePersistence.MarkDirty()
+	}
+	return entry
+}
+
 func (a *GeoBlock) getCountryCode(req *http.Request, ipAddressString string) (string, error) {
 	if len(a.iPGeolocationHTTPHeaderField) != 0 {
 		country, err := a.readIPGeolocationHTTPHeader(req, a.iPGeolocationHTTPHeaderField)
 		if err == nil {
+			explainFrom(req).lookup(sourceHeader, country)
 			return country, nil
 		}
 
@@ -569,6 +1132,7 @@ func (a *GeoBlock) getCountryCode(req *http.Request, ipAddressString string) (st
 		return "", err
 	}
 
+	explainFrom(req).lookup(sourceAPI, country)
 	return country, nil
 }
 
@@ -645,20 +1209,7 @@ func stringInSlice(a string, list []string) bool {
 }
 
 func formatIPList(ips []*net.IP) string {
-	parts := make([]string, 0, len(ips))
-	for _, ip := range ips {
-		parts = append(parts, ip.String())
-	}
-	return strings.Join(parts, ", ")
-}
-
-func ipInSlice(a net.IP, list []net.IP) bool {
-	for _, b := range list {
-		if b.Equal(a) {
-			return true
-		}
-	}
-	return false
+	return strings.Join(ipStrings(ips), ", ")
 }
 
 func parseIP(addr string) (net.IP, error) {
@@ -702,18 +1253,12 @@ func initPrivateIPBlocks() []*net.IPNet {
 	return privateIPBlocks
 }
 
-func isPrivateIP(ip net.IP, privateIPBlocks []*net.IPNet) bool {
+func isPrivateIP(ip net.IP, privateIPBlocks *iptrie.Trie) bool {
 	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
 		return true
 	}
 
-	for _, block := range privateIPBlocks {
-		if block.Contains(ip) {
-			return true
-		}
-	}
-
-	return false
+	return privateIPBlocks.Contains(ip)
 }
 
 func getHTTPStatusCodeDeniedRequest(code int) (int, error) {
@@ -729,31 +1274,6 @@ func getHTTPStatusCodeDeniedRequest(code int) (int, error) {
 	return defaultDeniedRequestHTTPStatusCode, nil
 }
 
-func parseAllowedIPAddresses(entries []string, logger *log.Logger) ([]net.IP, []*net.IPNet) {
-	var allowedIPAddresses []net.IP
-	var allowedIPRanges []*net.IPNet
-
-	for _, ipAddressEntry := range entries {
-		ipAddressEntry = strings.Trim(ipAddressEntry, " ")
-		// Attempt to parse as CIDR
-		ip, ipBlock, err := net.ParseCIDR(ipAddressEntry)
-		if err == nil {
-			allowedIPAddresses = append(allowedIPAddresses, ip)
-			allowedIPRanges = append(allowedIPRanges, ipBlock)
-			continue
-		}
-
-		// Attempt to parse as a single IP address
-		ipAddress := net.ParseIP(ipAddressEntry)
-		if ipAddress == nil {
-			logger.Fatal("Invalid IP address provided:", ipAddressEntry)
-		}
-		allowedIPAddresses = append(allowedIPAddresses, ipAddress)
-	}
-
-	return allowedIPAddresses, allowedIPRanges
-}
-
 func compileExcludedPathPatterns(patterns []string) ([]*regexp.Regexp, error) {
 	var regexps []*regexp.Regexp
 
@@ -792,14 +1312,83 @@ func printConfiguration(name string, config *Config, logger *log.Logger) {
 	logger.Printf("%s: allow unknown countries: %t", name, config.AllowUnknownCountries)
 	logger.Printf("%s: unknown country api response: %s", name, config.UnknownCountryAPIResponse)
 	logger.Printf("%s: blacklist mode: %t", name, config.BlackListMode)
-	logger.Printf("%s: add country header: %t", name, config.AddCountryHeader)
+	printSteeringConfiguration(name, config, logger)
+	printBanConfiguration(name, config, logger)
+	printCountryPolicyConfiguration(name, config, logger)
+	printTarpitConfiguration(name, config, logger)
 	logger.Printf("%s: countries: %v", name, config.Countries)
-	logger.Printf("%s: Denied request status code: %d", name, config.HTTPStatusCodeDeniedRequest)
+	printScheduleConfiguration(name, config, logger)
+	printDeniedResponseConfiguration(name, config, logger)
 	logger.Printf("%s: Log file path: %s", name, config.LogFilePath)
+	if len(config.ExcludedPathPatterns) > 0 {
+		logger.Printf("%s: Excluded path patterns: %v", name, config.ExcludedPathPatterns)
+	}
+	if len(config.AdminAPIPath) != 0 {
+		logger.Printf("%s: Admin API path: %s", name, config.AdminAPIPath)
+	}
+	if len(config.DeniedIPAddresses) > 0 {
+		logger.Printf("%s: Denied IP addresses: %v", name, config.DeniedIPAddresses)
+	}
+	if len(config.AllowedIPAddressesFile) != 0 {
+		logger.Printf("%s: Allowed IP addresses file: %s", name, config.AllowedIPAddressesFile)
+	}
+	if len(config.DeniedIPAddressesFile) != 0 {
+		logger.Printf("%s: Denied IP addresses file: %s", name, config.DeniedIPAddressesFile)
+	}
+	for _, source := range config.IPListSources {
+		logger.Printf("%s: IP list source (%s): %s", name, source.Action, source.URL)
+	}
+	if len(config.CountryOverrides) > 0 {
+		logger.Printf("%s: Country overrides: %v", name, config.CountryOverrides)
+	}
+	printLookupConfiguration(name, config, logger)
+	logger.Printf("%s: Explain decisions: %t", name, len(config.ExplainSecret) != 0)
+	printBypassConfiguration(name, config, logger)
+	printVerifiedBotConfiguration(name, config, logger)
+}
+
+// printLookupConfiguration prints the ASN and subdivision sources and rules.
+func printLookupConfiguration(name string, config *Config, logger *log.Logger) {
+	if len(config.ASNDatabasePath) != 0 {
+		logger.Printf("%s: ASN database: %s", name, config.ASNDatabasePath)
+	}
+	if len(config.ASNAPI) != 0 {
+		logger.Printf("%s: ASN API uri: %s [%s]", name, config.ASNAPI, config.ASNAPIField)
+	}
+	if len(config.AllowedASNs) > 0 {
+		logger.Printf("%s: Allowed ASNs: %v", name, config.AllowedASNs)
+	}
+	if len(config.DeniedASNs) > 0 {
+		logger.Printf("%s: Denied ASNs: %v", name, config.DeniedASNs)
+	}
+	logger.Printf("%s: add ASN header: %t", name, config.AddASNHeader)
+	if len(config.SubdivisionDatabasePath) != 0 {
+		logger.Printf("%s: Subdivision database: %s", name, config.SubdivisionDatabasePath)
+	}
+	if len(config.SubdivisionAPI) != 0 {
+		logger.Printf("%s: Subdivision API uri: %s [%s]", name, config.SubdivisionAPI, config.SubdivisionAPIField)
+	}
+	logger.Printf("%s: add region header: %t", name, config.AddRegionHeader)
+}
+
+func printDeniedResponseConfiguration(name string, config *Config, logger *log.Logger) {
+	logger.Printf("%s: Denied request status code: %d", name, config.HTTPStatusCodeDeniedRequest)
+	if len(config.DeniedResponseTemplateFile) != 0 {
+		logger.Printf("%s: Denied response template file: %s", name, config.DeniedResponseTemplateFile)
+	} else {
+		logger.Printf("%s: Denied response template: %t", name, len(config.DeniedResponseTemplate) != 0)
+	}
+	if len(config.DeniedResponseTemplates)+len(config.DeniedResponseTemplateFiles) > 0 {
+		logger.Printf("%s: Localized denied response templates: %d", name,
+			len(config.DeniedResponseTemplates)+len(config.DeniedResponseTemplateFiles))
+	}
 	if len(config.RedirectURLIfDenied) != 0 {
 		logger.Printf("%s: Redirect URL on denied requests: %s", name, config.RedirectURLIfDenied)
 	}
-	if len(config.ExcludedPathPatterns) > 0 {
-		logger.Printf("%s: Excluded path patterns: %v", name, config.ExcludedPathPatterns)
+	if len(config.RedirectURLsByCountry) > 0 {
+		logger.Printf("%s: Redirect URLs by country: %v", name, config.RedirectURLsByCountry)
+	}
+	if len(config.RedirectURLIfDenied) != 0 || len(config.RedirectURLsByCountry) > 0 {
+		logger.Printf("%s: Redirect status code: %d", name, config.RedirectStatusCode)
 	}
 }
@@ -109,6 +109,35 @@ func TestInvalidDeniedRequestStatusCode(t *testing.T) {
 	}
 }
 
+func TestInvalidConfigReportsAllErrors(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.Countries = append(cfg.Countries, "CH", "Switzerland")
+	cfg.HTTPStatusCodeDeniedRequest = 1
+	cfg.AllowedIPAddresses = append(cfg.AllowedIPAddresses, "8.8.8.8", "1.2.3.4.5")
+	cfg.ExcludedPathPatterns = append(cfg.ExcludedPathPatterns, "^/api/(")
+	cfg.LogFilePath = "/does/not/exist/info.log"
+
+	ctx := context.Background()
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+
+	_, err := geoblock.New(ctx, next, cfg, t.Name())
+	if err == nil {
+		t.Fatal("expected error for invalid configuration")
+	}
+
+	for _, expected := range []string{
+		"invalid country code [Switzerland]",
+		"invalid denied request status code",
+		"invalid IP address or range [1.2.3.4.5]",
+		"invalid regex pattern [^/api/(]",
+		"log file path",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in error, got:\n%v", expected, err)
+		}
+	}
+}
+
 func TestAllowedCountry(t *testing.T) {
 	cfg := createTesterConfig()
 	cfg.Countries = append(cfg.Countries, "CH")
@@ -369,6 +398,47 @@ func createMockAPIServer(t *testing.T, ipResponseMap map[string][]byte) *httptes
 	}))
 }
 
+// exampleCountries are the countries of the example IP addresses.
+var exampleCountries = map[string]string{caExampleIP: "CA", chExampleIP: "CH"}
+
+// newCountryAPIHandler creates the middleware named after the test, with the
+// API answering the country of the IP addresses in countries and 404 for any
+// other IP address. A nil next handler does nothing.
+func newCountryAPIHandler(
+	t *testing.T, cfg *geoblock.Config, countries map[string]string, next http.Handler,
+) (http.Handler, error) {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		country, ok := countries[strings.TrimPrefix(req.URL.Path, "/")]
+		if !ok {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = rw.Write([]byte(country))
+	}))
+	t.Cleanup(server.Close)
+
+	cfg.API = server.URL + "/{ip}"
+	if next == nil {
+		next = http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+	}
+	return geoblock.New(context.Background(), next, cfg, t.Name())
+}
+
+// createCountryAPIHandler is newCountryAPIHandler failing the test on error.
+func createCountryAPIHandler(
+	t *testing.T, cfg *geoblock.Config, countries map[string]string, next http.Handler,
+) http.Handler {
+	t.Helper()
+
+	handler, err := newCountryAPIHandler(t, cfg, countries, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return handler
+}
+
 func TestMultipleIpAddresses(t *testing.T) {
 	mockServer := createMockAPIServer(t, map[string][]byte{caExampleIP: []byte(`CA`), chExampleIP: []byte(`CH`)})
 	defer mockServer.Close()
@@ -1903,6 +1973,33 @@ func TestGetOrInitCache_SharedByName(t *testing.T) {
 	}
 }
 
+func TestGetOrInitCache_ResizesOnReload(t *testing.T) {
+	t.Parallel()
+
+	cache, _, err := geoblock.GetOrInitCache(geoblock.Options{Name: t.Name(), CacheSize: 4})
+	if err != nil {
+		t.Fatalf("GetOrInitCache(initial) failed: %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		cache.Add(fmt.Sprintf("10.0.0.%d", i), true)
+	}
+
+	// A reload with a smaller cacheSize must shrink the warm cache in place.
+	reloaded, _, err := geoblock.GetOrInitCache(geoblock.Options{Name: t.Name(), CacheSize: 2})
+	if err != nil {
+		t.Fatalf("GetOrInitCache(reload) failed: %v", err)
+	}
+	if reloaded != cache {
+		t.Fatal("expected the warm cache to be reused on reload")
+	}
+	if reloaded.Size() != 2 || reloaded.Length() != 2 {
+		t.Fatalf("expected cache resized to 2, got size=%d length=%d", reloaded.Size(), reloaded.Length())
+	}
+	if _, ok := reloaded.Get("10.0.0.3"); !ok {
+		t.Fatal("expected the most recent entry to survive the resize")
+	}
+}
+
 func waitForFileNonEmpty(t *testing.T, path string, timeout time.Duration) {
 	t.Helper()
 
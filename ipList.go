@@ -0,0 +1,177 @@
+package geoblock
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PascalMinder/geoblock/iptrie"
+)
+
+const (
+	sourceConfig = "config"
+	sourceFile   = "file"
+
+	defaultIPListFilePollInterval = 10 * time.Second
+)
+
+// ipList is an allow or deny list of IP address ranges. The ranges are
+// collected per source (the plugin configuration, a file, ...) and merged
+// into a trie, which is swapped atomically whenever a source changes, so
+// lookups never block and never see a partially built list.
+type ipList struct {
+	kind    string // "allowed" or "denied", used in log messages
+	trie    atomic.Value
+	mu      sync.Mutex // serializes source updates
+	sources map[string][]*net.IPNet
+	remotes []*remoteIPList // remote sources, looked up in their own tries
+	name    string
+	logger  *log.Logger
+}
+
+// ipListFile tracks the state of a file source between two polls.
+type ipListFile struct {
+	path    string
+	modTime time.Time
+	size    int64
+	lastErr string
+}
+
+func newIPList(kind string, ipNets []*net.IPNet, logger *log.Logger, name string) *ipList {
+	list := &ipList{
+		kind:    kind,
+		sources: map[string][]*net.IPNet{sourceConfig: ipNets},
+		name:    name,
+		logger:  logger,
+	}
+	list.trie.Store(newIPTrie(ipNets))
+	return list
+}
+
+// Contains reports whether the IP address is contained in the list.
+func (l *ipList) Contains(ip net.IP) bool {
+	if l.trie.Load().(*iptrie.Trie).Contains(ip) {
+		return true
+	}
+	for _, remote := range l.remotes {
+		if remote.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// setSource replaces the ranges of a source and rebuilds the trie.
+func (l *ipList) setSource(source string, ipNets []*net.IPNet) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sources[source] = ipNets
+
+	trie := iptrie.NewTrie()
+	for _, sourceIPNets := range l.sources {
+		for _, ipNet := range sourceIPNets {
+			trie.Insert(ipNet, true)
+		}
+	}
+	l.trie.Store(trie)
+}
+
+// watchFile loads the IP address ranges from the file and polls it for
+// changes until the context is done. The initial load must succeed; later
+// errors are logged and the last good list is kept.
+func (l *ipList) watchFile(ctx context.Context, path string, interval time.Duration) error {
+	file := &ipListFile{path: path}
+	if _, err := l.reloadFile(file); err != nil {
+		return fmt.Errorf("%s IP addresses file: %w", l.kind, err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				l.pollFile(file)
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (l *ipList) pollFile(file *ipListFile) {
+	reloaded, err := l.reloadFile(file)
+	if err != nil {
+		// report every error once instead of on every poll
+		if err.Error() != file.lastErr {
+			l.logger.Printf("%s: failed to reload %s IP addresses from %s, keeping the last good list: %v",
+				l.name, l.kind, file.path, err)
+		}
+		file.lastErr = err.Error()
+		return
+	}
+
+	file.lastErr = ""
+	if reloaded {
+		l.logger.Printf("%s: reloaded %s IP addresses from %s", l.name, l.kind, file.path)
+	}
+}
+
+// reloadFile parses the file if it changed since the last successful load.
+func (l *ipList) reloadFile(file *ipListFile) (bool, error) {
+	info, err := os.Stat(file.path)
+	if err != nil {
+		return false, err
+	}
+	if info.ModTime().Equal(file.modTime) && info.Size() == file.size {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(file.path)
+	if err != nil {
+		return false, err
+	}
+	ipNets, err := parseIPListFile(data)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", file.path, err)
+	}
+
+	l.setSource(sourceFile, ipNets)
+	file.modTime, file.size = info.ModTime(), info.Size()
+	return true, nil
+}
+
+// parseIPListFile parses a newline separated list of IP addresses and CIDR
+// ranges. Everything after a '#' is a comment; empty lines are ignored.
+func parseIPListFile(data []byte) ([]*net.IPNet, error) {
+	var ipNets []*net.IPNet
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		parsed, err := parseIPNets([]string{line})
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		ipNets = append(ipNets, parsed...)
+	}
+
+	return ipNets, scanner.Err()
+}
@@ -0,0 +1,376 @@
+package geoblock
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PascalMinder/geoblock/iptrie"
+)
+
+const (
+	ipListActionAllow = "allow"
+	ipListActionDeny  = "deny"
+
+	ipListFormatCIDR = "cidr"
+	ipListFormatJSON = "json"
+
+	defaultIPListSourceRefreshInterval = time.Hour
+	ipListSourceTimeout                = 30 * time.Second
+	ipListSourceMaxSize                = 32 << 20 // bytes
+)
+
+// IPListSource is a remote list of IP addresses and ranges, e.g. the ranges
+// of a cloud provider or the Tor exit nodes.
+type IPListSource struct {
+	URL            string `yaml:"url"`
+	RefreshSeconds int    `yaml:"refreshSeconds"`
+	Format         string `yaml:"format"`
+	JSONPath       string `yaml:"jsonPath"`
+	Action         string `yaml:"action"`
+}
+
+// remoteIPList keeps an IP list source up to date. It is shared by the
+// instances of the middleware, so the source is fetched and its disk copy
+// written once; the lists of the instances look the ranges up in its trie.
+type remoteIPList struct {
+	source IPListSource
+	client *http.Client
+	name   string
+	logger *log.Logger
+	stop   context.CancelFunc
+	trie   atomic.Value // *iptrie.Trie of the last good list, empty until loaded
+
+	mu        sync.Mutex
+	cachePath string // disk copy of the last good list, empty if disabled
+	interval  time.Duration
+}
+
+var (
+	sharedIPListSourcesMu sync.Mutex
+	// running sources per middleware name and source
+	sharedIPListSources = map[string]map[string]*remoteIPList{}
+)
+
+func validateIPListSource(source IPListSource) error {
+	if !strings.HasPrefix(source.URL, "http://") && !strings.HasPrefix(source.URL, "https://") {
+		return fmt.Errorf("IP list source: invalid URL [%s]", source.URL)
+	}
+
+	switch source.Action {
+	case ipListActionAllow, ipListActionDeny:
+	default:
+		return fmt.Errorf("IP list source [%s]: invalid action [%s], must be %q or %q",
+			source.URL, source.Action, ipListActionAllow, ipListActionDeny)
+	}
+
+	switch source.Format {
+	case "", ipListFormatCIDR:
+	case ipListFormatJSON:
+		if len(source.JSONPath) == 0 {
+			return fmt.Errorf("IP list source [%s]: json format requires a jsonPath", source.URL)
+		}
+	default:
+		return fmt.Errorf("IP list source [%s]: unknown format [%s]", source.URL, source.Format)
+	}
+
+	return nil
+}
+
+// startIPListSources adds the configured sources to the lists of the
+// instance. The sources are shared per middleware name: the first instance
+// using a source loads its disk copy and starts fetching it in the
+// background, and an instance built without it, e.g. after a configuration
+// reload, stops it.
+func startIPListSources(config *Config, allowedIPs, deniedIPs *ipList, logger *log.Logger, name string) error {
+	for _, source := range config.IPListSources {
+		if err := validateIPListSource(source); err != nil {
+			return err
+		}
+	}
+
+	sharedIPListSourcesMu.Lock()
+	defer sharedIPListSourcesMu.Unlock()
+
+	running := sharedIPListSources[name]
+	sources := make(map[string]*remoteIPList, len(config.IPListSources))
+	for _, source := range config.IPListSources {
+		key := strings.Join([]string{source.Action, source.Format, source.JSONPath, source.URL}, "\x00")
+		if _, ok := sources[key]; ok {
+			continue
+		}
+
+		remote, ok := running[key]
+		if !ok {
+			remote = newRemoteIPList(source, logger, name)
+		}
+		remote.configure(config, source)
+		if !ok {
+			remote.start()
+		}
+		sources[key] = remote
+
+		if source.Action == ipListActionDeny {
+			deniedIPs.remotes = append(deniedIPs.remotes, remote)
+		} else {
+			allowedIPs.remotes = append(allowedIPs.remotes, remote)
+		}
+	}
+
+	for key, remote := range running {
+		if _, ok := sources[key]; !ok {
+			remote.stop()
+		}
+	}
+	sharedIPListSources[name] = sources
+
+	return nil
+}
+
+func newRemoteIPList(source IPListSource, logger *log.Logger, name string) *remoteIPList {
+	remote := &remoteIPList{
+		source: source,
+		client: &http.Client{Timeout: ipListSourceTimeout},
+		name:   name,
+		logger: logger,
+	}
+	remote.trie.Store(iptrie.NewTrie())
+	return remote
+}
+
+// configure sets the refresh interval and the path of the disk copy, which a
+// reload may change.
+func (r *remoteIPList) configure(config *Config, source IPListSource) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.interval = defaultIPListSourceRefreshInterval
+	if source.RefreshSeconds > 0 {
+		r.interval = time.Duration(source.RefreshSeconds) * time.Second
+	}
+	r.cachePath = ""
+	if len(config.IPDatabaseCachePath) != 0 {
+		r.cachePath = ipListSourceCachePath(config.IPDatabaseCachePath, source.URL)
+	}
+}
+
+// start loads the disk copy and starts fetching the source in the background,
+// detached from the instance, which may go away before the others.
+func (r *remoteIPList) start() {
+	r.loadCopy()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.stop = cancel
+	go r.run(ctx)
+}
+
+// Contains reports whether the IP address is contained in the last good list.
+func (r *remoteIPList) Contains(ip net.IP) bool {
+	return r.trie.Load().(*iptrie.Trie).Contains(ip)
+}
+
+// ipListSourceCachePath returns the path of the disk copy of a source, next
+// to the IP database cache.
+func ipListSourceCachePath(ipDatabaseCachePath, url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(filepath.Dir(ipDatabaseCachePath), "geoblock-list-"+hex.EncodeToString(sum[:8])+".txt")
+}
+
+// kind returns the kind of the lists the source is used by.
+func (r *remoteIPList) kind() string {
+	if r.source.Action == ipListActionDeny {
+		return "denied"
+	}
+	return "allowed"
+}
+
+// publish replaces the last good list.
+func (r *remoteIPList) publish(ipNets []*net.IPNet) {
+	r.trie.Store(newIPTrie(ipNets))
+}
+
+func (r *remoteIPList) copyPath() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cachePath
+}
+
+// loadCopy loads the disk copy of the last good list for cold starts.
+func (r *remoteIPList) loadCopy() {
+	cachePath := r.copyPath()
+	if len(cachePath) == 0 {
+		return
+	}
+
+	data, err := os.ReadFile(cachePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			r.logger.Printf("%s: failed to read IP list copy %s: %v", r.name, cachePath, err)
+		}
+		return
+	}
+
+	ipNets, err := parseIPListFile(data)
+	if err != nil {
+		r.logger.Printf("%s: ignoring IP list copy %s: %v", r.name, cachePath, err)
+		return
+	}
+
+	r.publish(ipNets)
+	r.logger.Printf("%s: loaded %d %s IP ranges of %s from %s", r.name, len(ipNets), r.kind(), r.source.URL, cachePath)
+}
+
+func (r *remoteIPList) run(ctx context.Context) {
+	for {
+		r.refresh(ctx)
+
+		r.mu.Lock()
+		timer := time.NewTimer(r.interval)
+		r.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// refresh fetches the source; on failure the last good list is kept.
+func (r *remoteIPList) refresh(ctx context.Context) {
+	ipNets, err := r.fetch(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			r.logger.Printf("%s: failed to fetch IP list %s, keeping the last good list: %v", r.name, r.source.URL, err)
+		}
+		return
+	}
+
+	r.publish(ipNets)
+	r.logger.Printf("%s: fetched %d %s IP ranges from %s", r.name, len(ipNets), r.kind(), r.source.URL)
+
+	if cachePath := r.copyPath(); len(cachePath) != 0 {
+		if err := writeFileAtomic(cachePath, "iplist-*.tmp", formatIPListFile(ipNets)); err != nil {
+			r.logger.Printf("%s: failed to write IP list copy %s: %v", r.name, cachePath, err)
+		}
+	}
+}
+
+func (r *remoteIPList) fetch(ctx context.Context) ([]*net.IPNet, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.source.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("response status code: %d", res.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, ipListSourceMaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > ipListSourceMaxSize {
+		return nil, fmt.Errorf("response exceeds %d bytes", ipListSourceMaxSize)
+	}
+
+	if r.source.Format == ipListFormatJSON {
+		return parseIPListJSON(data, r.source.JSONPath)
+	}
+	return parseIPListFile(data)
+}
+
+// parseIPListJSON extracts the IP ranges at the path from a JSON document.
+// The path is a dot separated list of fields; a field suffixed with "[]" is
+// an array whose elements are walked, e.g. "prefixes[].ip_prefix".
+func parseIPListJSON(data []byte, path string) ([]*net.IPNet, error) {
+	var document interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+
+	values, err := jsonPathValues(document, strings.Split(path, "."))
+	if err != nil {
+		return nil, fmt.Errorf("json path [%s]: %w", path, err)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("json path [%s]: no IP ranges found", path)
+	}
+
+	entries := make([]string, 0, len(values))
+	for _, value := range values {
+		entry, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("json path [%s]: expected a string, got %T", path, value)
+		}
+		entries = append(entries, entry)
+	}
+
+	return parseIPNets(entries)
+}
+
+// jsonPathValues returns the values at the path in a decoded JSON document.
+func jsonPathValues(value interface{}, path []string) ([]interface{}, error) {
+	if len(path) == 0 {
+		return []interface{}{value}, nil
+	}
+
+	field, isArray := strings.CutSuffix(path[0], "[]")
+	if len(field) != 0 {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected an object for [%s], got %T", field, value)
+		}
+		if value, ok = object[field]; !ok {
+			// e.g. IPv4 and IPv6 prefixes in the same array use different fields
+			return nil, nil
+		}
+	}
+
+	if !isArray {
+		return jsonPathValues(value, path[1:])
+	}
+
+	elements, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected an array for [%s], got %T", path[0], value)
+	}
+
+	var values []interface{}
+	for _, element := range elements {
+		elementValues, err := jsonPathValues(element, path[1:])
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, elementValues...)
+	}
+	return values, nil
+}
+
+func formatIPListFile(ipNets []*net.IPNet) []byte {
+	var sb strings.Builder
+	for _, ipNet := range ipNets {
+		sb.WriteString(ipNet.String())
+		sb.WriteByte('\n')
+	}
+	return []byte(sb.String())
+}
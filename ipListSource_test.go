@@ -0,0 +1,198 @@
+package geoblock_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	geoblock "github.com/PascalMinder/geoblock"
+)
+
+const awsIPRanges = `{
+  "syncToken": "1",
+  "prefixes": [
+    {"ip_prefix": "99.220.109.0/24", "service": "EC2"},
+    {"ip_prefix": "198.51.100.0/24", "service": "ROUTE53_HEALTHCHECKS"}
+  ],
+  "ipv6_prefixes": [
+    {"ipv6_prefix": "2001:db8::/32", "service": "EC2"}
+  ]
+}`
+
+func createIPListSourceHandler(ctx context.Context, t *testing.T, sourceURL, cachePath string) http.Handler {
+	t.Helper()
+
+	apiStub := httptest.NewServer(&CountryCodeHandler{ResponseCountryCode: "CA"})
+	t.Cleanup(apiStub.Close)
+
+	cfg := createTesterConfig()
+	cfg.API = apiStub.URL + "/{ip}"
+	cfg.Countries = append(cfg.Countries, "CH")
+	cfg.IPDatabaseCachePath = cachePath
+	cfg.IPListSources = []geoblock.IPListSource{{
+		URL:            sourceURL,
+		RefreshSeconds: 1,
+		Format:         "json",
+		JSONPath:       "prefixes[].ip_prefix",
+		Action:         "allow",
+	}}
+
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+
+	handler, err := geoblock.New(ctx, next, cfg, t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return handler
+}
+
+func TestIPListSourceFetchAndColdStart(t *testing.T) {
+	var failing int32
+	source := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = rw.Write([]byte(awsIPRanges))
+	}))
+	defer source.Close()
+
+	cachePath := filepath.Join(t.TempDir(), "ip-cache.db")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	handler := createIPListSourceHandler(ctx, t, source.URL, cachePath)
+	waitForStatusCode(t, handler, caExampleIP, http.StatusOK, 5*time.Second)
+
+	// a failing source keeps the last good list
+	atomic.StoreInt32(&failing, 1)
+	time.Sleep(1500 * time.Millisecond)
+	assertStatusCode(t, geoblockRequest(handler, caExampleIP), http.StatusOK)
+	cancel()
+
+	// a cold start of another middleware uses the copy next to the IP database cache
+	t.Run("cold start", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		handler := createIPListSourceHandler(ctx, t, source.URL, cachePath)
+		assertStatusCode(t, geoblockRequest(handler, caExampleIP), http.StatusOK)
+	})
+}
+
+func TestIPListSourceDenyCIDRLines(t *testing.T) {
+	source := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		_, _ = rw.Write([]byte("# exit nodes\n" + chExampleIP + "\n"))
+	}))
+	defer source.Close()
+
+	cfg := createTesterConfig()
+	cfg.Countries = append(cfg.Countries, "CH")
+	cfg.AllowedIPAddresses = append(cfg.AllowedIPAddresses, chExampleIP)
+	cfg.IPListSources = []geoblock.IPListSource{{URL: source.URL, Action: "deny"}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+
+	handler, err := geoblock.New(ctx, next, cfg, t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitForStatusCode(t, handler, chExampleIP, http.StatusForbidden, 5*time.Second)
+}
+
+func TestIPListSourceInvalidConfig(t *testing.T) {
+	for _, source := range []geoblock.IPListSource{
+		{URL: "ftp://example.com/list.txt", Action: "allow"},
+		{URL: "https://example.com/list.txt", Action: "block"},
+		{URL: "https://example.com/list.txt", Action: "deny", Format: "xml"},
+		{URL: "https://example.com/list.json", Action: "deny", Format: "json"},
+	} {
+		cfg := createTesterConfig()
+		cfg.Countries = append(cfg.Countries, "CH")
+		cfg.IPListSources = []geoblock.IPListSource{source}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+
+		if _, err := geoblock.New(ctx, next, cfg, t.Name()); err == nil {
+			t.Errorf("expected error for IP list source %+v", source)
+		}
+		cancel()
+	}
+}
+
+func TestIPListSourceSharedPerMiddleware(t *testing.T) {
+	var fetches int32
+	source := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		_, _ = rw.Write([]byte(chExampleIP + "\n"))
+	}))
+	defer source.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+
+	// one instance per router, all sharing the middleware name
+	handlers := make([]http.Handler, 0, 3)
+	for i := 0; i < 3; i++ {
+		cfg := createTesterConfig()
+		cfg.Countries = append(cfg.Countries, "CA")
+		cfg.IPListSources = []geoblock.IPListSource{{URL: source.URL, RefreshSeconds: 3600, Action: "allow"}}
+
+		handler, err := geoblock.New(ctx, next, cfg, t.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		handlers = append(handlers, handler)
+	}
+
+	for _, handler := range handlers {
+		waitForStatusCode(t, handler, chExampleIP, http.StatusOK, 5*time.Second)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Fatalf("expected the source to be fetched once, got %d fetches", n)
+	}
+}
+
+func TestIPListSourceStoppedAfterReload(t *testing.T) {
+	var fetches int32
+	source := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		_, _ = rw.Write([]byte(chExampleIP + "\n"))
+	}))
+	defer source.Close()
+
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+	newHandler := func(sources []geoblock.IPListSource) http.Handler {
+		cfg := createTesterConfig()
+		cfg.Countries = append(cfg.Countries, "CA")
+		cfg.IPListSources = sources
+
+		// never done, like the context of a middleware in Traefik
+		handler, err := geoblock.New(context.Background(), next, cfg, t.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return handler
+	}
+
+	handler := newHandler([]geoblock.IPListSource{{URL: source.URL, RefreshSeconds: 1, Action: "allow"}})
+	waitForStatusCode(t, handler, chExampleIP, http.StatusOK, 5*time.Second)
+
+	// the reloaded middleware no longer uses the source
+	handler = newHandler(nil)
+	assertStatusCode(t, geoblockRequest(handler, chExampleIP), http.StatusForbidden)
+
+	time.Sleep(100 * time.Millisecond) // let a fetch in flight finish
+	n := atomic.LoadInt32(&fetches)
+	time.Sleep(1500 * time.Millisecond)
+	if got := atomic.LoadInt32(&fetches); got != n {
+		t.Fatalf("expected the source to be stopped, got %d fetches after the reload", got-n)
+	}
+}
@@ -0,0 +1,120 @@
+package geoblock_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	geoblock "github.com/PascalMinder/geoblock"
+)
+
+// writeIPListFile writes the file and moves its modification time forward,
+// so a change is detected even on file systems with a coarse mtime.
+func writeIPListFile(t *testing.T, path, content string, age time.Duration) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write IP list failed: %v", err)
+	}
+	modTime := time.Now().Add(age)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("chtimes failed: %v", err)
+	}
+}
+
+func waitForStatusCode(t *testing.T, handler http.Handler, ip string, expected int, timeout time.Duration) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		if geoblockRequest(handler, ip).StatusCode == expected {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("status code of [%s] did not become %d within %s", ip, expected, timeout)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestAllowedIPAddressesFileHotReload(t *testing.T) {
+	server := httptest.NewServer(&CountryCodeHandler{ResponseCountryCode: "CA"})
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "allowed.txt")
+	writeIPListFile(t, path, "# partner ranges\n\n192.0.2.0/24 # partner A\n", -time.Hour)
+
+	cfg := createTesterConfig()
+	cfg.API = server.URL + "/{ip}"
+	cfg.Countries = append(cfg.Countries, "CH")
+	cfg.AllowedIPAddressesFile = path
+	cfg.IPAddressesFilePollSeconds = 1
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+
+	handler, err := geoblock.New(ctx, next, cfg, t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertStatusCode(t, geoblockRequest(handler, "192.0.2.10"), http.StatusOK)
+	assertStatusCode(t, geoblockRequest(handler, caExampleIP), http.StatusForbidden)
+
+	writeIPListFile(t, path, "192.0.2.0/24\n"+caExampleIP+"\n", 0)
+	waitForStatusCode(t, handler, caExampleIP, http.StatusOK, 5*time.Second)
+
+	// a broken file keeps the last good list
+	writeIPListFile(t, path, "192.0.2.0/24\nnot-an-ip\n", time.Minute)
+	time.Sleep(1500 * time.Millisecond)
+	assertStatusCode(t, geoblockRequest(handler, caExampleIP), http.StatusOK)
+}
+
+func TestDeniedIPAddressesFileCombinedWithConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "denied.txt")
+	writeIPListFile(t, path, chExampleIP+"\n", 0)
+
+	cfg := createTesterConfig()
+	cfg.Countries = append(cfg.Countries, "CH")
+	cfg.AllowLocalRequests = true
+	cfg.DeniedIPAddresses = append(cfg.DeniedIPAddresses, "10.0.0.0/8")
+	cfg.DeniedIPAddressesFile = path
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+
+	handler, err := geoblock.New(ctx, next, cfg, t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertStatusCode(t, geoblockRequest(handler, chExampleIP), http.StatusForbidden)
+	assertStatusCode(t, geoblockRequest(handler, "10.0.0.1"), http.StatusForbidden)
+	assertStatusCode(t, geoblockRequest(handler, privateRangeIP), http.StatusOK)
+}
+
+func TestIPAddressesFileInvalidOnStartUp(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.txt")
+	writeIPListFile(t, invalid, "192.0.2.0/24\n192.0.2.300\n", 0)
+
+	for _, path := range []string{invalid, filepath.Join(dir, "missing.txt")} {
+		cfg := createTesterConfig()
+		cfg.Countries = append(cfg.Countries, "CH")
+		cfg.AllowedIPAddressesFile = path
+
+		ctx, cancel := context.WithCancel(context.Background())
+		next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+
+		if _, err := geoblock.New(ctx, next, cfg, t.Name()); err == nil {
+			t.Errorf("expected error for IP addresses file %s", path)
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,47 @@
+package geoblock
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/PascalMinder/geoblock/iptrie"
+)
+
+// parseIPNets parses a list of IP addresses and CIDR ranges. Single addresses
+// become host routes (/32 or /128).
+func parseIPNets(entries []string) ([]*net.IPNet, error) {
+	ipNets := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if _, ipNet, err := net.ParseCIDR(entry); err == nil {
+			ipNets = append(ipNets, ipNet)
+			continue
+		}
+
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address or range [%s]", entry)
+		}
+		ipNets = append(ipNets, hostIPNet(ip))
+	}
+	return ipNets, nil
+}
+
+// hostIPNet returns the host route (/32 or /128) of an IP address.
+func hostIPNet(ip net.IP) *net.IPNet {
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 8*net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+}
+
+// newIPTrie builds a prefix trie for fast lookups in a list of IP ranges.
+func newIPTrie(ipNets []*net.IPNet) *iptrie.Trie {
+	trie := iptrie.NewTrie()
+	for _, ipNet := range ipNets {
+		trie.Insert(ipNet, true)
+	}
+	return trie
+}
@@ -0,0 +1,209 @@
+// The iptrie package provides a path-compressed binary trie for IP prefixes
+package iptrie
+
+import (
+	"net"
+)
+
+const (
+	ipv4Bits = 8 * net.IPv4len
+	ipv6Bits = 8 * net.IPv6len
+
+	// IPv4-mapped IPv6 prefixes (::ffff:0:0/96) are stored as IPv4 prefixes.
+	ipv4MappedBits = ipv6Bits - ipv4Bits
+)
+
+// Trie stores IPv4 and IPv6 prefixes with an associated value and supports
+// longest-prefix-match lookups. Nodes with a single child are compressed into
+// their parent, so a lookup visits at most one node per distinct prefix
+// length on the path, independent of the number of stored prefixes.
+//
+// A Trie is not safe for concurrent modification. Concurrent lookups are
+// safe once all prefixes have been inserted.
+type Trie struct {
+	v4     *node
+	v6     *node
+	length int
+}
+
+type node struct {
+	key      []byte // prefix, bits after the prefix length are zero
+	bits     int    // prefix length
+	children [2]*node
+	value    interface{}
+	hasValue bool // false for branch nodes created by path compression
+}
+
+// NewTrie creates an empty trie.
+func NewTrie() *Trie {
+	return &Trie{}
+}
+
+// Insert adds the prefix with the given value. The value of an already
+// existing prefix is replaced.
+func (t *Trie) Insert(ipNet *net.IPNet, value interface{}) {
+	key, bits, ok := prefixKey(ipNet)
+	if !ok {
+		return
+	}
+
+	root := &t.v6
+	if len(key) == net.IPv4len {
+		root = &t.v4
+	}
+
+	if t.insert(root, key, bits, value) {
+		t.length++
+	}
+}
+
+// insert returns true if a new prefix was added.
+func (t *Trie) insert(current **node, key []byte, bits int, value interface{}) bool {
+	for {
+		n := *current
+		if n == nil {
+			*current = &node{key: key, bits: bits, value: value, hasValue: true}
+			return true
+		}
+
+		common := commonPrefixLen(n.key, key, minInt(n.bits, bits))
+		switch {
+		case common == n.bits && common == bits:
+			// same prefix
+			added := !n.hasValue
+			n.value, n.hasValue = value, true
+			return added
+
+		case common == n.bits:
+			// the new prefix is below the node
+			current = &n.children[bitAt(key, n.bits)]
+
+		case common == bits:
+			// the new prefix is above the node
+			parent := &node{key: key, bits: bits, value: value, hasValue: true}
+			parent.children[bitAt(n.key, bits)] = n
+			*current = parent
+			return true
+
+		default:
+			// the prefixes diverge, add a branch node
+			branch := &node{key: maskKey(key, common), bits: common}
+			branch.children[bitAt(n.key, common)] = n
+			branch.children[bitAt(key, common)] = &node{key: key, bits: bits, value: value, hasValue: true}
+			*current = branch
+			return true
+		}
+	}
+}
+
+// Lookup returns the value of the longest prefix containing the IP address.
+func (t *Trie) Lookup(ip net.IP) (value interface{}, ok bool) {
+	n := t.v6
+	key := ip.To4()
+	if key != nil {
+		n = t.v4
+	} else if key = ip.To16(); key == nil {
+		return nil, false
+	}
+
+	maxBits := 8 * len(key)
+	for n != nil {
+		if n.bits > maxBits || commonPrefixLen(n.key, key, n.bits) < n.bits {
+			break
+		}
+		if n.hasValue {
+			value, ok = n.value, true
+		}
+		if n.bits == maxBits {
+			break
+		}
+		n = n.children[bitAt(key, n.bits)]
+	}
+
+	return value, ok
+}
+
+// Contains reports whether the IP address is contained in any prefix.
+func (t *Trie) Contains(ip net.IP) bool {
+	_, ok := t.Lookup(ip)
+	return ok
+}
+
+// Len returns the number of stored prefixes.
+func (t *Trie) Len() int {
+	return t.length
+}
+
+// prefixKey returns the masked prefix bytes and the prefix length.
+func prefixKey(ipNet *net.IPNet) ([]byte, int, bool) {
+	if ipNet == nil {
+		return nil, 0, false
+	}
+
+	ones, size := ipNet.Mask.Size()
+	if size == 0 {
+		return nil, 0, false // non-canonical mask
+	}
+
+	if ip4 := ipNet.IP.To4(); ip4 != nil {
+		if size == ipv6Bits {
+			if ones < ipv4MappedBits {
+				// covers more than the IPv4-mapped range
+				return maskKey(ipNet.IP.To16(), ones), ones, true
+			}
+			ones -= ipv4MappedBits
+		}
+		return maskKey(ip4, ones), ones, true
+	}
+
+	ip6 := ipNet.IP.To16()
+	if ip6 == nil || size != ipv6Bits {
+		return nil, 0, false
+	}
+	return maskKey(ip6, ones), ones, true
+}
+
+// maskKey returns a copy of key with all bits after the prefix length zeroed.
+func maskKey(key []byte, bits int) []byte {
+	masked := make([]byte, len(key))
+	copy(masked, key)
+	for i := range masked {
+		switch {
+		case bits >= 8*(i+1):
+			continue
+		case bits <= 8*i:
+			masked[i] = 0
+		default:
+			masked[i] &= ^byte(0xff >> uint(bits-8*i))
+		}
+	}
+	return masked
+}
+
+// commonPrefixLen returns the number of leading bits a and b share, at most maxBits.
+func commonPrefixLen(a, b []byte, maxBits int) int {
+	for i := 0; i < maxBits; i += 8 {
+		diff := a[i/8] ^ b[i/8]
+		if diff == 0 {
+			continue
+		}
+		for bit := i; bit < maxBits; bit++ {
+			if diff&(0x80>>uint(bit-i)) != 0 {
+				return bit
+			}
+		}
+		return maxBits
+	}
+	return maxBits
+}
+
+func bitAt(key []byte, bit int) int {
+	return int(key[bit/8]>>uint(7-bit%8)) & 1
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
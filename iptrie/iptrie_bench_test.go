@@ -0,0 +1,38 @@
+package iptrie
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"testing"
+)
+
+// BenchmarkLookup compares the trie with the linear scan over a slice of
+// prefixes that was used before.
+func BenchmarkLookup(b *testing.B) {
+	for _, count := range []int{10, 1000, 50000} {
+		rnd := rand.New(rand.NewSource(1))
+		ipNets := randomIPv4Nets(rnd, count)
+		ips := make([]net.IP, 1024)
+		for i := range ips {
+			ips[i] = randomIPv4(rnd)
+		}
+
+		trie := NewTrie()
+		for _, ipNet := range ipNets {
+			trie.Insert(ipNet, true)
+		}
+
+		b.Run(fmt.Sprintf("trie/%d", count), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				trie.Contains(ips[i%len(ips)])
+			}
+		})
+
+		b.Run(fmt.Sprintf("slice/%d", count), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				sliceContains(ipNets, ips[i%len(ips)])
+			}
+		})
+	}
+}
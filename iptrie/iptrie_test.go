@@ -0,0 +1,149 @@
+package iptrie
+
+import (
+	"math/rand"
+	"net"
+	"testing"
+)
+
+func mustParseCIDR(t testing.TB, cidr string) *net.IPNet {
+	t.Helper()
+
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatalf("ParseCIDR(%q) error = %v", cidr, err)
+	}
+	return ipNet
+}
+
+func TestTrieLongestPrefixMatch(t *testing.T) {
+	trie := NewTrie()
+	for _, cidr := range []string{
+		"10.0.0.0/8",
+		"10.1.0.0/16",
+		"10.1.2.0/24",
+		"10.1.2.3/32",
+		"192.168.0.0/16",
+		"2001:db8::/32",
+		"2001:db8:1::/48",
+	} {
+		trie.Insert(mustParseCIDR(t, cidr), cidr)
+	}
+
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"10.9.9.9", "10.0.0.0/8"},
+		{"10.1.9.9", "10.1.0.0/16"},
+		{"10.1.2.9", "10.1.2.0/24"},
+		{"10.1.2.3", "10.1.2.3/32"},
+		{"192.168.255.1", "192.168.0.0/16"},
+		{"2001:db8:2::1", "2001:db8::/32"},
+		{"2001:db8:1:ffff::1", "2001:db8:1::/48"},
+		{"::ffff:10.1.2.3", "10.1.2.3/32"},
+		{"11.0.0.1", ""},
+		{"192.169.0.1", ""},
+		{"2001:db9::1", ""},
+	}
+
+	for _, tt := range tests {
+		value, ok := trie.Lookup(net.ParseIP(tt.ip))
+		if tt.want == "" {
+			if ok {
+				t.Errorf("Lookup(%s) = %v, want no match", tt.ip, value)
+			}
+			continue
+		}
+		if !ok || value != tt.want {
+			t.Errorf("Lookup(%s) = %v, %t, want %v", tt.ip, value, ok, tt.want)
+		}
+	}
+}
+
+func TestTrieInsertOrderAndReplace(t *testing.T) {
+	trie := NewTrie()
+	// insert the more specific prefixes first to exercise node splits
+	trie.Insert(mustParseCIDR(t, "172.16.5.0/24"), "a")
+	trie.Insert(mustParseCIDR(t, "172.16.6.0/24"), "b")
+	trie.Insert(mustParseCIDR(t, "172.16.0.0/12"), "c")
+	trie.Insert(mustParseCIDR(t, "172.16.5.0/24"), "d")
+
+	if trie.Len() != 3 {
+		t.Errorf("Len() = %v, want %v", trie.Len(), 3)
+	}
+
+	for ip, want := range map[string]string{"172.16.5.1": "d", "172.16.6.1": "b", "172.20.0.1": "c"} {
+		if value, _ := trie.Lookup(net.ParseIP(ip)); value != want {
+			t.Errorf("Lookup(%s) = %v, want %v", ip, value, want)
+		}
+	}
+}
+
+func TestTrieDefaultRoute(t *testing.T) {
+	trie := NewTrie()
+	trie.Insert(mustParseCIDR(t, "0.0.0.0/0"), true)
+
+	if !trie.Contains(net.ParseIP("203.0.113.1")) {
+		t.Error("Contains() = false, want true for IPv4 default route")
+	}
+	if trie.Contains(net.ParseIP("2001:db8::1")) {
+		t.Error("Contains() = true, want false for IPv6 address")
+	}
+}
+
+func TestTrieHostAddresses(t *testing.T) {
+	trie := NewTrie()
+	trie.Insert(&net.IPNet{IP: net.ParseIP("192.0.2.1").To4(), Mask: net.CIDRMask(32, 32)}, 1)
+	trie.Insert(&net.IPNet{IP: net.ParseIP("2001:db8::1"), Mask: net.CIDRMask(128, 128)}, 2)
+
+	if !trie.Contains(net.ParseIP("192.0.2.1")) || trie.Contains(net.ParseIP("192.0.2.2")) {
+		t.Error("unexpected IPv4 host address match")
+	}
+	if !trie.Contains(net.ParseIP("2001:db8::1")) || trie.Contains(net.ParseIP("2001:db8::2")) {
+		t.Error("unexpected IPv6 host address match")
+	}
+	if trie.Contains(nil) {
+		t.Error("Contains(nil) = true, want false")
+	}
+}
+
+func TestTrieMatchesSliceScan(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	ipNets := randomIPv4Nets(rnd, 2000)
+
+	trie := NewTrie()
+	for _, ipNet := range ipNets {
+		trie.Insert(ipNet, true)
+	}
+
+	for i := 0; i < 10000; i++ {
+		ip := randomIPv4(rnd)
+		if got, want := trie.Contains(ip), sliceContains(ipNets, ip); got != want {
+			t.Fatalf("Contains(%s) = %t, want %t", ip, got, want)
+		}
+	}
+}
+
+func randomIPv4(rnd *rand.Rand) net.IP {
+	return net.IPv4(byte(rnd.Intn(256)), byte(rnd.Intn(256)), byte(rnd.Intn(256)), byte(rnd.Intn(256))).To4()
+}
+
+func randomIPv4Nets(rnd *rand.Rand, count int) []*net.IPNet {
+	ipNets := make([]*net.IPNet, 0, count)
+	for i := 0; i < count; i++ {
+		bits := 8 + rnd.Intn(25)
+		mask := net.CIDRMask(bits, ipv4Bits)
+		ipNets = append(ipNets, &net.IPNet{IP: randomIPv4(rnd).Mask(mask), Mask: mask})
+	}
+	return ipNets
+}
+
+func sliceContains(ipNets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range ipNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -17,6 +17,22 @@ type LRUCache struct {
 	size      int
 	evictList *list.List
 	items     map[interface{}]*list.Element
+	onEvict   EvictCallback
+}
+
+// EvictCallback is called with the key and value of every entry dropped
+// because the cache ran out of capacity (Add, Resize, Import). It is not
+// called for Remove or Purge, and never while the cache lock is held.
+type EvictCallback func(key, value interface{})
+
+// Option configures an LRUCache at construction time.
+type Option func(*LRUCache)
+
+// OnEvict registers a callback for capacity evictions.
+func OnEvict(fn EvictCallback) Option {
+	return func(c *LRUCache) {
+		c.onEvict = fn
+	}
 }
 
 // Entry struct containing key value pair to represent a cache entry
@@ -35,27 +51,33 @@ type onDisk struct {
 	Entries []kv
 }
 
+var errInvalidSize = errors.New("cache size must be bigger than 1")
+
 // New constructs a new cache instance
-func NewLRUCache(size int) (*LRUCache, error) {
+func NewLRUCache(size int, opts ...Option) (*LRUCache, error) {
 	// no use for a cache with one entry
 	if size <= 1 {
-		return nil, errors.New("cache size must be bigger than 1")
+		return nil, errInvalidSize
 	}
-	return &LRUCache{
+	c := &LRUCache{
 		size:      size,
 		evictList: list.New(),
 		items:     make(map[interface{}]*list.Element),
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
 }
 
 func (c *LRUCache) Add(key, value interface{}) (evicted bool) {
 	c.lock.Lock()
-	defer c.lock.Unlock()
 
 	// update existing
 	if e, ok := c.items[key]; ok {
 		c.evictList.MoveToFront(e)
 		e.Value.(*cacheEntry).value = value
+		c.lock.Unlock()
 		return false
 	}
 
@@ -65,11 +87,11 @@ func (c *LRUCache) Add(key, value interface{}) (evicted bool) {
 	c.items[key] = entry
 
 	// evict if needed
-	if c.evictList.Len() > c.size {
-		c.removeOldest()
-		return true
-	}
-	return false
+	evictedEntries := c.trimToSize()
+	c.lock.Unlock()
+
+	c.notifyEvicted(evictedEntries)
+	return len(evictedEntries) > 0
 }
 
 func (c *LRUCache) Get(key interface{}) (value interface{}, ok bool) {
@@ -90,6 +112,18 @@ func (c *LRUCache) Get(key interface{}) (value interface{}, ok bool) {
 	return e.Value.(*cacheEntry).value, true
 }
 
+// Peek returns a key's value without updating the recent-ness.
+func (c *LRUCache) Peek(key interface{}) (value interface{}, ok bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	return e.Value.(*cacheEntry).value, true
+}
+
 func (c *LRUCache) Contains(key interface{}) (ok bool) {
 	c.lock.RLock()
 	_, ok = c.items[key]
@@ -127,6 +161,30 @@ func (c *LRUCache) Length() int {
 	return c.evictList.Len()
 }
 
+// Size returns the configured capacity of the cache.
+func (c *LRUCache) Size() int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.size
+}
+
+// Resize changes the capacity of the cache at runtime, evicting the least
+// recently used entries if the new size is smaller than the current length.
+// It returns the number of evicted entries.
+func (c *LRUCache) Resize(size int) (evicted int, err error) {
+	if size <= 1 {
+		return 0, errInvalidSize
+	}
+
+	c.lock.Lock()
+	c.size = size
+	evictedEntries := c.trimToSize()
+	c.lock.Unlock()
+
+	c.notifyEvicted(evictedEntries)
+	return len(evictedEntries), nil
+}
+
 func (c *LRUCache) Purge() {
 	c.lock.Lock()
 	for k := range c.items {
@@ -136,9 +194,31 @@ func (c *LRUCache) Purge() {
 	c.lock.Unlock()
 }
 
-func (c *LRUCache) removeOldest() {
+func (c *LRUCache) removeOldest() *cacheEntry {
 	if e := c.evictList.Back(); e != nil {
 		c.removeElement(e)
+		return e.Value.(*cacheEntry)
+	}
+	return nil
+}
+
+// trimToSize evicts LRU entries until the cache fits its size. The caller
+// must hold the write lock and hand the result to notifyEvicted once the
+// lock is released.
+func (c *LRUCache) trimToSize() []*cacheEntry {
+	var evicted []*cacheEntry
+	for c.evictList.Len() > c.size {
+		evicted = append(evicted, c.removeOldest())
+	}
+	return evicted
+}
+
+func (c *LRUCache) notifyEvicted(entries []*cacheEntry) {
+	if c.onEvict == nil {
+		return
+	}
+	for _, e := range entries {
+		c.onEvict(e.key, e.value)
 	}
 }
 
@@ -184,34 +264,49 @@ func (c *LRUCache) Export(w io.Writer) error {
 	return gob.NewEncoder(w).Encode(&data)
 }
 
-// Import replaces the cache contents, preserving LRU order.
-// Assumes Entries are MRU -> LRU (same as Export).
-func (c *LRUCache) Import(r io.Reader) error {
+// DecodeSnapshot reads a gob snapshot written by Export without touching any
+// cache, so callers can filter the entries (MRU -> LRU) before loading them.
+func DecodeSnapshot(r io.Reader) (size int, entries []Pair, err error) {
 	var data onDisk
 	if err := gob.NewDecoder(r).Decode(&data); err != nil {
-		return err
+		return 0, nil, err
 	}
 	if data.Size <= 1 {
-		return errors.New("invalid cache size in import")
+		return 0, nil, errors.New("invalid cache size in import")
+	}
+
+	entries = make([]Pair, len(data.Entries))
+	for i, p := range data.Entries {
+		entries[i] = Pair{Key: p.K, Value: p.V}
+	}
+	return data.Size, entries, nil
+}
+
+// Import replaces the cache contents, preserving LRU order.
+// Assumes Entries are MRU -> LRU (same as Export).
+func (c *LRUCache) Import(r io.Reader) error {
+	size, entries, err := DecodeSnapshot(r)
+	if err != nil {
+		return err
 	}
 
 	c.lock.Lock()
-	defer c.lock.Unlock()
 
-	c.size = data.Size
-	c.items = make(map[interface{}]*list.Element, len(data.Entries))
+	c.size = size
+	c.items = make(map[interface{}]*list.Element, len(entries))
 	c.evictList.Init()
 
 	// Rebuild: PushBack in MRU -> LRU order keeps MRU at Front, LRU at Back.
-	for _, p := range data.Entries {
-		ent := &cacheEntry{key: p.K, value: p.V}
+	for _, p := range entries {
+		ent := &cacheEntry{key: p.Key, value: p.Value}
 		el := c.evictList.PushBack(ent)
-		c.items[p.K] = el
+		c.items[p.Key] = el
 	}
 
-	for c.evictList.Len() > c.size {
-		c.removeOldest()
-	}
+	evictedEntries := c.trimToSize()
+	c.lock.Unlock()
+
+	c.notifyEvicted(evictedEntries)
 	return nil
 }
 
@@ -10,6 +10,9 @@ type Cache interface {
 	// Return a key's value if found in the cache and updates the recent-ness.
 	Get(key interface{}) (value interface{}, ok bool)
 
+	// Return a key's value if found in the cache without updating the recent-ness.
+	Peek(key interface{}) (value interface{}, ok bool)
+
 	// Check if a key exists without updating the recent-ness.
 	Contains(key interface{}) (ok bool)
 
@@ -24,4 +27,11 @@ type Cache interface {
 
 	// Remove all entries from the cache.
 	Purge()
+
+	// Return the configured capacity of the cache.
+	Size() int
+
+	// Change the capacity of the cache, evicting the oldest entries if needed.
+	// Returns the number of evicted entries.
+	Resize(size int) (int, error)
 }
@@ -489,3 +489,123 @@ func TestLRUCacheExportImportEmptyCache(t *testing.T) {
 		t.Fatalf("expected imported size 3, got %d", got)
 	}
 }
+
+func TestLRUCacheOnEvictCallback(t *testing.T) {
+	var evictedKeys []interface{}
+	cache, err := NewLRUCache(2, OnEvict(func(key, _ interface{}) {
+		evictedKeys = append(evictedKeys, key)
+	}))
+	if err != nil {
+		t.Fatalf("NewLRUCache failed: %v", err)
+	}
+
+	cache.Add("A", 1)
+	cache.Add("B", 2)
+	cache.Add("A", 3) // update, no eviction; A becomes MRU
+	cache.Add("C", 4) // evicts B
+
+	if want := []interface{}{"B"}; !reflect.DeepEqual(want, evictedKeys) {
+		t.Fatalf("unexpected evictions: want=%v got=%v", want, evictedKeys)
+	}
+
+	// Remove and Purge are explicit and must not be reported as evictions.
+	cache.Remove("A")
+	cache.Purge()
+	if len(evictedKeys) != 1 {
+		t.Fatalf("Remove/Purge must not trigger the evict callback, got %v", evictedKeys)
+	}
+}
+
+func TestLRUCacheResizeShrink(t *testing.T) {
+	evictions := 0
+	cache, _ := NewLRUCache(5, OnEvict(func(_, _ interface{}) { evictions++ }))
+	for i := 0; i < 5; i++ {
+		cache.Add("K"+fmt.Sprint(i), i)
+	}
+
+	evicted, err := cache.Resize(3)
+	if err != nil {
+		t.Fatalf("Resize failed: %v", err)
+	}
+	if evicted != 2 || evictions != 2 {
+		t.Fatalf("expected 2 evictions, got return=%d callback=%d", evicted, evictions)
+	}
+	if cache.Size() != 3 || cache.Length() != 3 {
+		t.Fatalf("unexpected size/length after shrink: size=%d length=%d", cache.Size(), cache.Length())
+	}
+
+	want := []interface{}{"K4", "K3", "K2"}
+	if got := cache.Keys(); !reflect.DeepEqual(want, got) {
+		t.Fatalf("oldest entries should be evicted first: want=%v got=%v", want, got)
+	}
+}
+
+func TestLRUCacheResizeGrow(t *testing.T) {
+	cache, _ := NewLRUCache(2)
+	cache.Add("A", 1)
+	cache.Add("B", 2)
+
+	evicted, err := cache.Resize(4)
+	if err != nil || evicted != 0 {
+		t.Fatalf("Resize(grow) = %d, %v; want 0, nil", evicted, err)
+	}
+
+	cache.Add("C", 3)
+	cache.Add("D", 4)
+	if cache.Length() != 4 {
+		t.Fatalf("expected grown cache to hold 4 entries, got %d", cache.Length())
+	}
+}
+
+func TestLRUCacheResizeInvalidSize(t *testing.T) {
+	cache, _ := NewLRUCache(2)
+	if _, err := cache.Resize(1); err == nil {
+		t.Fatal("expected error when resizing to 1")
+	}
+	if cache.Size() != 2 {
+		t.Fatalf("invalid resize must not change size, got %d", cache.Size())
+	}
+}
+
+func TestDecodeSnapshotDoesNotNeedCache(t *testing.T) {
+	gob.Register(userData{})
+
+	cache, _ := NewLRUCache(3)
+	cache.Add("A", userData{"A", time.Now()})
+	cache.Add("B", userData{"B", time.Now()})
+
+	var buf bytes.Buffer
+	if err := cache.Export(&buf); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	size, entries, err := DecodeSnapshot(&buf)
+	if err != nil {
+		t.Fatalf("DecodeSnapshot failed: %v", err)
+	}
+	if size != 3 {
+		t.Fatalf("unexpected size: want=3 got=%d", size)
+	}
+	if len(entries) != 2 || entries[0].Key != "B" || entries[1].Key != "A" {
+		t.Fatalf("unexpected entries (want MRU -> LRU): %+v", entries)
+	}
+}
+
+func TestLRUCachePeekDoesNotMutateRecency(t *testing.T) {
+	cache, _ := NewLRUCache(3)
+	cache.Add("A", 1)
+	cache.Add("B", 2)
+
+	v, ok := cache.Peek("A")
+	if !ok || v != 1 {
+		t.Fatalf("Peek(A) = %v, %v; want 1, true", v, ok)
+	}
+	if _, ok := cache.Peek("C"); ok {
+		t.Fatal("Peek must not find missing keys")
+	}
+
+	want := []interface{}{"B", "A"}
+	if got := cache.Keys(); !reflect.DeepEqual(want, got) {
+		t.Fatalf("Peek changed recency: want=%v got=%v", want, got)
+	}
+}
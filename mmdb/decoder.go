@@ -0,0 +1,249 @@
+package mmdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// Data section field types.
+const (
+	typeExtended  = 0
+	typePointer   = 1
+	typeString    = 2
+	typeDouble    = 3
+	typeBytes     = 4
+	typeUint16    = 5
+	typeUint32    = 6
+	typeMap       = 7
+	typeInt32     = 8
+	typeUint64    = 9
+	typeUint128   = 10
+	typeArray     = 11
+	typeContainer = 12
+	typeEndMarker = 13
+	typeBool      = 14
+	typeFloat     = 15
+)
+
+// maxDecodeDepth limits the nesting of maps, arrays and pointers, so a
+// corrupt file cannot recurse endlessly.
+const maxDecodeDepth = 32
+
+var errTruncated = errors.New("unexpected end of data section")
+
+// decoder decodes values from the data section (or the metadata section).
+type decoder struct {
+	buffer []byte
+}
+
+// decode returns the value at the offset and the offset after it.
+func (d *decoder) decode(offset uint) (interface{}, uint, error) {
+	return d.decodeDepth(offset, 0)
+}
+
+func (d *decoder) decodeDepth(offset uint, depth int) (interface{}, uint, error) {
+	if depth > maxDecodeDepth {
+		return nil, 0, errors.New("maximum data structure depth exceeded")
+	}
+
+	typ, size, offset, err := d.decodeControl(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if typ == typePointer {
+		pointer, next, err := d.decodePointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decodeDepth(pointer, depth+1)
+		return value, next, err
+	}
+
+	switch typ {
+	case typeMap:
+		return d.decodeMap(size, offset, depth)
+	case typeArray:
+		return d.decodeArray(size, offset, depth)
+	case typeBool:
+		return size != 0, offset, nil
+	}
+
+	data, next, err := d.read(offset, size)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	value, err := decodeScalar(typ, data)
+	return value, next, err
+}
+
+// decodeControl decodes the control byte(s) and returns type, payload size
+// and the offset of the payload. For pointers, size holds the control byte.
+func (d *decoder) decodeControl(offset uint) (int, uint, uint, error) {
+	control, offset, err := d.readByte(offset)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	typ := int(control >> 5)
+	if typ == typePointer {
+		return typ, uint(control), offset, nil
+	}
+	if typ == typeExtended {
+		var extended byte
+		if extended, offset, err = d.readByte(offset); err != nil {
+			return 0, 0, 0, err
+		}
+		typ = int(extended) + 7
+	}
+
+	size := uint(control & 0x1f)
+	if size < 29 {
+		return typ, size, offset, nil
+	}
+
+	extra := size - 28 // number of additional size bytes
+	data, offset, err := d.read(offset, extra)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	switch extra {
+	case 1:
+		size = 29 + uint(data[0])
+	case 2:
+		size = 285 + (uint(data[0])<<8 | uint(data[1]))
+	default:
+		size = 65821 + (uint(data[0])<<16 | uint(data[1])<<8 | uint(data[2]))
+	}
+	return typ, size, offset, nil
+}
+
+func (d *decoder) decodePointer(control, offset uint) (uint, uint, error) {
+	pointerSize := ((control >> 3) & 0x3) + 1
+	data, next, err := d.read(offset, pointerSize)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var pointer uint
+	if pointerSize < 4 {
+		pointer = control & 0x7
+	}
+	for _, b := range data {
+		pointer = pointer<<8 | uint(b)
+	}
+
+	switch pointerSize {
+	case 2:
+		pointer += 2048
+	case 3:
+		pointer += 526336
+	}
+	return pointer, next, nil
+}
+
+func (d *decoder) decodeMap(size, offset uint, depth int) (interface{}, uint, error) {
+	values := make(map[string]interface{}, size)
+	for i := uint(0); i < size; i++ {
+		key, next, err := d.decodeDepth(offset, depth+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		keyString, ok := key.(string)
+		if !ok {
+			return nil, 0, fmt.Errorf("map key has type %T, expected string", key)
+		}
+
+		var value interface{}
+		if value, offset, err = d.decodeDepth(next, depth+1); err != nil {
+			return nil, 0, err
+		}
+		values[keyString] = value
+	}
+	return values, offset, nil
+}
+
+func (d *decoder) decodeArray(size, offset uint, depth int) (interface{}, uint, error) {
+	values := make([]interface{}, 0, size)
+	for i := uint(0); i < size; i++ {
+		value, next, err := d.decodeDepth(offset, depth+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		values = append(values, value)
+		offset = next
+	}
+	return values, offset, nil
+}
+
+func decodeScalar(typ int, data []byte) (interface{}, error) {
+	switch typ {
+	case typeString:
+		return string(data), nil
+	case typeBytes:
+		return append([]byte(nil), data...), nil
+	case typeDouble:
+		if len(data) != 8 {
+			return nil, fmt.Errorf("invalid double size %d", len(data))
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(data)), nil
+	case typeFloat:
+		if len(data) != 4 {
+			return nil, fmt.Errorf("invalid float size %d", len(data))
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(data)), nil
+	case typeUint16, typeUint32, typeUint64, typeInt32:
+		return decodeInt(typ, data)
+	case typeUint128:
+		if len(data) > 16 {
+			return nil, fmt.Errorf("invalid uint128 size %d", len(data))
+		}
+		return new(big.Int).SetBytes(data), nil
+	case typeContainer, typeEndMarker:
+		return nil, fmt.Errorf("unsupported data type %d", typ)
+	default:
+		return nil, fmt.Errorf("unknown data type %d", typ)
+	}
+}
+
+func decodeInt(typ int, data []byte) (interface{}, error) {
+	maxSize := map[int]int{typeUint16: 2, typeUint32: 4, typeUint64: 8, typeInt32: 4}[typ]
+	if len(data) > maxSize {
+		return nil, fmt.Errorf("invalid size %d for integer type %d", len(data), typ)
+	}
+
+	var value uint64
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+
+	switch typ {
+	case typeUint16:
+		return uint16(value), nil
+	case typeUint32:
+		return uint32(value), nil
+	case typeInt32:
+		return int32(uint32(value)), nil
+	default:
+		return value, nil
+	}
+}
+
+func (d *decoder) readByte(offset uint) (byte, uint, error) {
+	if offset >= uint(len(d.buffer)) {
+		return 0, 0, errTruncated
+	}
+	return d.buffer[offset], offset + 1, nil
+}
+
+func (d *decoder) read(offset, size uint) ([]byte, uint, error) {
+	end := offset + size
+	if end < offset || end > uint(len(d.buffer)) {
+		return nil, 0, errTruncated
+	}
+	return d.buffer[offset:end], end, nil
+}
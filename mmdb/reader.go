@@ -0,0 +1,240 @@
+// The mmdb package provides a minimal reader for MaxMind DB files
+// (https://maxmind.github.io/MaxMind-DB/), e.g. the GeoLite2 ASN and City
+// databases.
+package mmdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+)
+
+// metadataStartMarker precedes the metadata section at the end of the file.
+var metadataStartMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+const (
+	// dataSectionSeparatorSize is the number of zero bytes between the search
+	// tree and the data section.
+	dataSectionSeparatorSize = 16
+
+	// maximum size of the metadata section searched for the start marker
+	maxMetadataSize = 128 * 1024
+)
+
+var errInvalidDatabase = errors.New("invalid MaxMind DB file")
+
+// Metadata describes the database.
+type Metadata struct {
+	NodeCount    uint
+	RecordSize   uint
+	IPVersion    uint
+	DatabaseType string
+	BuildEpoch   uint64
+}
+
+// Reader looks up IP addresses in a MaxMind DB file held in memory.
+// A Reader is safe for concurrent use.
+type Reader struct {
+	buffer    []byte
+	metadata  Metadata
+	treeSize  uint
+	ipv4Start uint
+}
+
+// Open reads the database file into memory.
+func Open(path string) (*Reader, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return FromBytes(data)
+}
+
+// FromBytes creates a reader for a database held in memory.
+func FromBytes(buffer []byte) (*Reader, error) {
+	searchStart := 0
+	if len(buffer) > maxMetadataSize {
+		searchStart = len(buffer) - maxMetadataSize
+	}
+	markerIndex := bytes.LastIndex(buffer[searchStart:], metadataStartMarker)
+	if markerIndex < 0 {
+		return nil, fmt.Errorf("%w: metadata not found", errInvalidDatabase)
+	}
+	metadataStart := searchStart + markerIndex + len(metadataStartMarker)
+
+	metadata, err := decodeMetadata(buffer[metadataStart:])
+	if err != nil {
+		return nil, err
+	}
+
+	reader := &Reader{
+		buffer:   buffer,
+		metadata: metadata,
+		treeSize: metadata.NodeCount * metadata.RecordSize / 4, // two records per node
+	}
+	if reader.treeSize+dataSectionSeparatorSize > uint(metadataStart) {
+		return nil, fmt.Errorf("%w: search tree exceeds file size", errInvalidDatabase)
+	}
+
+	reader.ipv4Start = reader.findIPv4Start()
+	return reader, nil
+}
+
+func decodeMetadata(buffer []byte) (Metadata, error) {
+	d := decoder{buffer: buffer}
+	value, _, err := d.decode(0)
+	if err != nil {
+		return Metadata{}, fmt.Errorf("%w: metadata: %w", errInvalidDatabase, err)
+	}
+	fields, ok := value.(map[string]interface{})
+	if !ok {
+		return Metadata{}, fmt.Errorf("%w: metadata is not a map", errInvalidDatabase)
+	}
+
+	metadata := Metadata{
+		NodeCount:  uint(toUint64(fields["node_count"])),
+		RecordSize: uint(toUint64(fields["record_size"])),
+		IPVersion:  uint(toUint64(fields["ip_version"])),
+		BuildEpoch: toUint64(fields["build_epoch"]),
+	}
+	metadata.DatabaseType, _ = fields["database_type"].(string)
+
+	switch metadata.RecordSize {
+	case 24, 28, 32:
+	default:
+		return Metadata{}, fmt.Errorf("%w: unsupported record size %d", errInvalidDatabase, metadata.RecordSize)
+	}
+	if metadata.IPVersion != 4 && metadata.IPVersion != 6 {
+		return Metadata{}, fmt.Errorf("%w: unsupported IP version %d", errInvalidDatabase, metadata.IPVersion)
+	}
+
+	return metadata, nil
+}
+
+// Metadata returns the database metadata.
+func (r *Reader) Metadata() Metadata {
+	return r.metadata
+}
+
+// Lookup returns the data record of the network containing the IP address.
+// The record is decoded into maps, slices, strings, bools and numbers.
+func (r *Reader) Lookup(ip net.IP) (record interface{}, ok bool, err error) {
+	node := uint(0)
+	key := ip.To4()
+	switch {
+	case key != nil && r.metadata.IPVersion == 6:
+		node = r.ipv4Start
+	case key == nil:
+		if key = ip.To16(); key == nil {
+			return nil, false, fmt.Errorf("invalid IP address [%s]", ip)
+		}
+		if r.metadata.IPVersion == 4 {
+			return nil, false, fmt.Errorf("IPv6 address [%s] in an IPv4 database", ip)
+		}
+	}
+
+	nodeCount := r.metadata.NodeCount
+	for i := 0; i < 8*len(key) && node < nodeCount; i++ {
+		bit := (key[i/8] >> uint(7-i%8)) & 1
+		node = r.readRecord(node, uint(bit))
+	}
+
+	switch {
+	case node == nodeCount:
+		return nil, false, nil
+	case node < nodeCount:
+		return nil, false, fmt.Errorf("%w: search tree too deep", errInvalidDatabase)
+	}
+
+	offset := node - nodeCount - dataSectionSeparatorSize
+	d := decoder{buffer: r.buffer[r.treeSize+dataSectionSeparatorSize:]}
+	record, _, err = d.decode(offset)
+	if err != nil {
+		return nil, false, err
+	}
+	return record, true, nil
+}
+
+// findIPv4Start returns the node of the IPv4 subtree (::/96) in an IPv6
+// database.
+func (r *Reader) findIPv4Start() uint {
+	if r.metadata.IPVersion != 6 {
+		return 0
+	}
+
+	node := uint(0)
+	for depth := 0; depth < 96 && node < r.metadata.NodeCount; depth++ {
+		node = r.readRecord(node, 0)
+	}
+	return node
+}
+
+func (r *Reader) readRecord(node, bit uint) uint {
+	b := r.buffer
+	switch r.metadata.RecordSize {
+	case 24:
+		offset := node*6 + bit*3
+		return uint(b[offset])<<16 | uint(b[offset+1])<<8 | uint(b[offset+2])
+	case 28:
+		offset := node * 7
+		if bit == 0 {
+			return uint(b[offset+3]&0xF0)<<20 | uint(b[offset])<<16 | uint(b[offset+1])<<8 | uint(b[offset+2])
+		}
+		return uint(b[offset+3]&0x0F)<<24 | uint(b[offset+4])<<16 | uint(b[offset+5])<<8 | uint(b[offset+6])
+	default:
+		offset := node*8 + bit*4
+		return uint(b[offset])<<24 | uint(b[offset+1])<<16 | uint(b[offset+2])<<8 | uint(b[offset+3])
+	}
+}
+
+// Value returns the value at the path in a decoded record. Path elements
+// are map keys (string) or array indices (int), e.g.
+// Value(record, "subdivisions", 0, "iso_code").
+func Value(record interface{}, path ...interface{}) (interface{}, bool) {
+	value := record
+	for _, element := range path {
+		switch key := element.(type) {
+		case string:
+			fields, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if value, ok = fields[key]; !ok {
+				return nil, false
+			}
+		case int:
+			elements, ok := value.([]interface{})
+			if !ok || key < 0 || key >= len(elements) {
+				return nil, false
+			}
+			value = elements[key]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// Uint converts the unsigned integer types returned for a record value.
+func Uint(value interface{}) (uint64, bool) {
+	switch v := value.(type) {
+	case uint16:
+		return uint64(v), true
+	case uint32:
+		return uint64(v), true
+	case uint64:
+		return v, true
+	case int32:
+		if v >= 0 {
+			return uint64(v), true
+		}
+	}
+	return 0, false
+}
+
+func toUint64(value interface{}) uint64 {
+	v, _ := Uint(value)
+	return v
+}
@@ -0,0 +1,305 @@
+package mmdb
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+// testNetwork is a network and its data record written by buildDatabase.
+// A record of type testPointer references the record of another network.
+type testNetwork struct {
+	cidr   string
+	record interface{}
+}
+
+type testPointer int
+
+type writerNode struct {
+	children [2]*writerNode
+	data     int // index of the network for leafs, -1 otherwise
+	index    int
+}
+
+// buildTree inserts the networks into a binary search tree whose leaves
+// are the indexes of the networks.
+func buildTree(t *testing.T, networks []testNetwork) *writerNode {
+	t.Helper()
+
+	root := &writerNode{data: -1}
+	for i, network := range networks {
+		_, ipNet, err := net.ParseCIDR(network.cidr)
+		if err != nil {
+			t.Fatalf("ParseCIDR(%q) error = %v", network.cidr, err)
+		}
+		ones, bits := ipNet.Mask.Size()
+		key := ipNet.IP.To16()
+		if bits == 8*net.IPv4len {
+			key = append(make([]byte, 12), ipNet.IP.To4()...)
+			ones += 96
+		}
+
+		node := root
+		for bit := 0; bit < ones-1; bit++ {
+			b := (key[bit/8] >> uint(7-bit%8)) & 1
+			if node.children[b] == nil {
+				node.children[b] = &writerNode{data: -1}
+			}
+			node = node.children[b]
+		}
+		b := (key[(ones-1)/8] >> uint(7-(ones-1)%8)) & 1
+		node.children[b] = &writerNode{data: i}
+	}
+	return root
+}
+
+// numberNodes numbers the inner nodes of the tree breadth first.
+func numberNodes(root *writerNode) []*writerNode {
+	var nodes []*writerNode
+	for queue := []*writerNode{root}; len(queue) > 0; queue = queue[1:] {
+		node := queue[0]
+		if node.data >= 0 {
+			continue
+		}
+		node.index = len(nodes)
+		nodes = append(nodes, node)
+		for _, child := range node.children {
+			if child != nil {
+				queue = append(queue, child)
+			}
+		}
+	}
+	return nodes
+}
+
+// buildDatabase writes an IPv6 MaxMind DB with the given record size.
+// IPv4 networks are stored in the IPv4 subtree (::/96), like GeoLite2 does.
+func buildDatabase(t *testing.T, recordSize int, networks []testNetwork) []byte {
+	t.Helper()
+
+	nodes := numberNodes(buildTree(t, networks))
+
+	// data section
+	var data bytes.Buffer
+	offsets := make([]int, len(networks))
+	for i, network := range networks {
+		offsets[i] = data.Len()
+		if pointer, ok := network.record.(testPointer); ok {
+			p := offsets[pointer]
+			data.Write([]byte{byte(typePointer<<5 | (p>>8)&0x7), byte(p)})
+			continue
+		}
+		data.Write(encodeValue(t, network.record))
+	}
+
+	nodeCount := len(nodes)
+	recordValue := func(child *writerNode) uint {
+		switch {
+		case child == nil:
+			return uint(nodeCount)
+		case child.data >= 0:
+			return uint(nodeCount + dataSectionSeparatorSize + offsets[child.data])
+		default:
+			return uint(child.index)
+		}
+	}
+
+	var buffer bytes.Buffer
+	for _, node := range nodes {
+		buffer.Write(encodeNode(recordSize, recordValue(node.children[0]), recordValue(node.children[1])))
+	}
+	buffer.Write(make([]byte, dataSectionSeparatorSize))
+	buffer.Write(data.Bytes())
+	buffer.Write(metadataStartMarker)
+	buffer.Write(encodeValue(t, map[string]interface{}{
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(recordSize),
+		"ip_version":                  uint16(6),
+		"database_type":               "GeoLite2-Test",
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(1700000000),
+		"languages":                   []interface{}{"en"},
+	}))
+	return buffer.Bytes()
+}
+
+func encodeNode(recordSize int, left, right uint) []byte {
+	switch recordSize {
+	case 24:
+		return []byte{byte(left >> 16), byte(left >> 8), byte(left), byte(right >> 16), byte(right >> 8), byte(right)}
+	case 28:
+		return []byte{
+			byte(left >> 16), byte(left >> 8), byte(left),
+			byte((left>>24)&0x0F)<<4 | byte((right>>24)&0x0F),
+			byte(right >> 16), byte(right >> 8), byte(right),
+		}
+	default:
+		return []byte{
+			byte(left >> 24), byte(left >> 16), byte(left >> 8), byte(left),
+			byte(right >> 24), byte(right >> 16), byte(right >> 8), byte(right),
+		}
+	}
+}
+
+func encodeControl(typ, size int) []byte {
+	var control []byte
+	switch {
+	case size < 29:
+		control = []byte{byte(size)}
+	case size < 285:
+		control = []byte{29, byte(size - 29)}
+	default:
+		size -= 285
+		control = []byte{30, byte(size >> 8), byte(size)}
+	}
+
+	if typ <= typeMap {
+		control[0] |= byte(typ << 5)
+		return control
+	}
+	return append([]byte{control[0], byte(typ - 7)}, control[1:]...)
+}
+
+func encodeUint(typ int, value uint64) []byte {
+	var data []byte
+	for ; value > 0; value >>= 8 {
+		data = append([]byte{byte(value)}, data...)
+	}
+	return append(encodeControl(typ, len(data)), data...)
+}
+
+func encodeValue(t *testing.T, value interface{}) []byte {
+	t.Helper()
+
+	switch v := value.(type) {
+	case string:
+		return append(encodeControl(typeString, len(v)), v...)
+	case uint16:
+		return encodeUint(typeUint16, uint64(v))
+	case uint32:
+		return encodeUint(typeUint32, uint64(v))
+	case uint64:
+		return encodeUint(typeUint64, v)
+	case bool:
+		size := 0
+		if v {
+			size = 1
+		}
+		return encodeControl(typeBool, size)
+	case []interface{}:
+		data := encodeControl(typeArray, len(v))
+		for _, element := range v {
+			data = append(data, encodeValue(t, element)...)
+		}
+		return data
+	case map[string]interface{}:
+		data := encodeControl(typeMap, len(v))
+		for key, element := range v {
+			data = append(data, encodeValue(t, key)...)
+			data = append(data, encodeValue(t, element)...)
+		}
+		return data
+	default:
+		t.Fatalf("unsupported test value %T", value)
+		return nil
+	}
+}
+
+func testASNNetworks() []testNetwork {
+	return []testNetwork{
+		{"1.1.1.0/24", map[string]interface{}{
+			"autonomous_system_number":       uint32(13335),
+			"autonomous_system_organization": "CLOUDFLARENET",
+		}},
+		{"1.0.0.0/24", testPointer(0)},
+		{"8.8.8.0/24", map[string]interface{}{
+			"autonomous_system_number": uint32(15169),
+			"is_anycast":               true,
+		}},
+		{"2001:db8::/32", map[string]interface{}{
+			"autonomous_system_number": uint32(64496),
+			"subdivisions": []interface{}{
+				map[string]interface{}{"iso_code": "CA"},
+			},
+		}},
+	}
+}
+
+func TestReaderLookup(t *testing.T) {
+	for _, recordSize := range []int{24, 28, 32} {
+		reader, err := FromBytes(buildDatabase(t, recordSize, testASNNetworks()))
+		if err != nil {
+			t.Fatalf("FromBytes(record size %d) error = %v", recordSize, err)
+		}
+
+		tests := []struct {
+			ip  string
+			asn uint64
+		}{
+			{"1.1.1.1", 13335},
+			{"1.0.0.1", 13335}, // pointer to the record above
+			{"8.8.8.8", 15169},
+			{"::ffff:8.8.4.4", 0},
+			{"2001:db8::1", 64496},
+			{"2001:db9::1", 0},
+			{"9.9.9.9", 0},
+		}
+		for _, tt := range tests {
+			record, ok, err := reader.Lookup(net.ParseIP(tt.ip))
+			if err != nil {
+				t.Fatalf("Lookup(%s) error = %v", tt.ip, err)
+			}
+			if tt.asn == 0 {
+				if ok {
+					t.Errorf("Lookup(%s) = %v, want not found", tt.ip, record)
+				}
+				continue
+			}
+
+			value, _ := Value(record, "autonomous_system_number")
+			if asn, _ := Uint(value); !ok || asn != tt.asn {
+				t.Errorf("Lookup(%s) ASN = %v, want %v (record size %d)", tt.ip, value, tt.asn, recordSize)
+			}
+		}
+	}
+}
+
+func TestReaderMetadataAndValue(t *testing.T) {
+	reader, err := FromBytes(buildDatabase(t, 24, testASNNetworks()))
+	if err != nil {
+		t.Fatalf("FromBytes() error = %v", err)
+	}
+
+	metadata := reader.Metadata()
+	if metadata.IPVersion != 6 || metadata.RecordSize != 24 || metadata.DatabaseType != "GeoLite2-Test" {
+		t.Errorf("Metadata() = %+v", metadata)
+	}
+
+	record, _, _ := reader.Lookup(net.ParseIP("2001:db8::1"))
+	if code, ok := Value(record, "subdivisions", 0, "iso_code"); !ok || code != "CA" {
+		t.Errorf("Value(subdivisions.0.iso_code) = %v, %t, want CA", code, ok)
+	}
+	if _, ok := Value(record, "subdivisions", 1, "iso_code"); ok {
+		t.Error("Value() with index out of range must not be found")
+	}
+
+	record, _, _ = reader.Lookup(net.ParseIP("8.8.8.8"))
+	if anycast, _ := Value(record, "is_anycast"); anycast != true {
+		t.Errorf("Value(is_anycast) = %v, want true", anycast)
+	}
+}
+
+func TestReaderInvalidDatabase(t *testing.T) {
+	if _, err := FromBytes([]byte("not a database")); err == nil {
+		t.Error("FromBytes() expected error for missing metadata")
+	}
+
+	data := buildDatabase(t, 24, testASNNetworks())
+	// drop the data section, the search tree now points beyond the file
+	truncated := append([]byte{}, data[:12]...)
+	truncated = append(truncated, data[bytes.LastIndex(data, metadataStartMarker):]...)
+	if _, err := FromBytes(truncated); err == nil {
+		t.Error("FromBytes() expected error for truncated database")
+	}
+}
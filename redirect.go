@@ -0,0 +1,220 @@
+package geoblock
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const defaultRedirectStatusCode = http.StatusFound
+
+// redirectPlaceholder matches the placeholders of a redirect URL.
+var redirectPlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+var redirectPlaceholders = map[string]bool{
+	"{country}": true,
+	"{host}":    true,
+	"{path}":    true,
+	"{query}":   true,
+	"{ip}":      true,
+}
+
+func validateRedirectConfig(config *Config) []error {
+	var errs []error
+
+	if _, err := getRedirectStatusCode(config.RedirectStatusCode); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(config.RedirectURLIfDenied) != 0 {
+		if err := validateRedirectURL(config.RedirectURLIfDenied); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for code, target := range config.RedirectURLsByCountry {
+		if upper := strings.ToUpper(code); !isCountryCode(upper) && !isSubdivisionCode(upper) {
+			errs = append(errs, fmt.Errorf("redirect URLs by country: invalid country code [%s]", code))
+		}
+		if err := validateRedirectURL(target); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
+
+func getRedirectStatusCode(code int) (int, error) {
+	switch code {
+	case 0:
+		return defaultRedirectStatusCode, nil
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return code, nil
+	default:
+		return 0, fmt.Errorf("invalid redirect status code [%d], must be 301, 302, 303, 307 or 308", code)
+	}
+}
+
+// validateRedirectURL checks that a redirect URL is an absolute http(s) URL
+// or an absolute path, and that a request cannot change its target: the
+// scheme and host contain no placeholders, except a host of just {host}.
+func validateRedirectURL(target string) error {
+	for _, placeholder := range redirectPlaceholder.FindAllString(target, -1) {
+		if !redirectPlaceholders[placeholder] {
+			return fmt.Errorf("redirect URL [%s]: unknown placeholder %s", target, placeholder)
+		}
+	}
+
+	scheme, host, absolute := splitRedirectURL(target)
+	if !absolute {
+		if !isLocalPath(target) {
+			return fmt.Errorf("redirect URL [%s] must be an absolute http(s) URL or start with a single /", target)
+		}
+		return nil
+	}
+
+	if scheme != "http" && scheme != "https" {
+		return fmt.Errorf("redirect URL [%s] must be an absolute http(s) URL or start with a single /", target)
+	}
+	if len(host) == 0 || (strings.ContainsAny(host, "{}") && host != "{host}") {
+		return fmt.Errorf("redirect URL [%s]: the host must be static or {host}", target)
+	}
+
+	return nil
+}
+
+// splitRedirectURL returns the scheme and host of an absolute redirect URL
+// (before expanding placeholders). {path} ends the host as it always expands
+// to a path starting with "/".
+func splitRedirectURL(target string) (scheme, host string, absolute bool) {
+	scheme, rest, absolute := strings.Cut(target, "://")
+	if !absolute {
+		return "", "", false
+	}
+
+	host = rest
+	if i := strings.IndexAny(host, "/?#"); i >= 0 {
+		host = host[:i]
+	}
+	host, _, _ = strings.Cut(host, "{path}")
+	return scheme, host, true
+}
+
+// isLocalPath reports whether the target is a path on the same host; "//"
+// and "/\" would be taken as the start of another host by browsers.
+func isLocalPath(target string) bool {
+	return strings.HasPrefix(target, "/") && !strings.HasPrefix(target, "//") && !strings.HasPrefix(target, `/\`)
+}
+
+// normalizeRedirectURLsByCountry upper-cases the country codes.
+func normalizeRedirectURLsByCountry(redirects map[string]string) map[string]string {
+	normalized := make(map[string]string, len(redirects))
+	for code, target := range redirects {
+		normalized[strings.ToUpper(code)] = target
+	}
+	return normalized
+}
+
+// redirectTarget returns the redirect URL for a denied request: the URL for
+// its subdivision or country, else redirectUrlIfDenied. It returns false if
+// no redirect is configured or the URL cannot be built safely.
+func (a *GeoBlock) redirectTarget(req *http.Request, result decision) (string, bool) {
+	target, ok := a.redirectURLsByCountry[result.entry.Subdivision]
+	if !ok {
+		target, ok = a.redirectURLsByCountry[result.entry.Country]
+	}
+	if !ok {
+		target = a.redirectURLIfDenied
+	}
+	if len(target) == 0 {
+		return "", false
+	}
+
+	location, err := expandRedirectURL(target, req, result)
+	if err != nil {
+		a.infoLogger.Printf("%s: not redirecting [%s]: %v", a.name, result.ip, err)
+		return "", false
+	}
+	return location, true
+}
+
+// expandRedirectURL replaces the placeholders with the escaped values of the
+// request and verifies the target of the result.
+func expandRedirectURL(target string, req *http.Request, result decision) (string, error) {
+	host := req.Host
+	if strings.Contains(target, "{host}") && !isValidHost(host) {
+		return "", fmt.Errorf("invalid host [%s]", host)
+	}
+
+	var ip string
+	if result.ip != nil {
+		ip = result.ip.String()
+	}
+
+	location := strings.NewReplacer(
+		"{country}", url.QueryEscape(result.entry.Country),
+		"{host}", host,
+		// never start with "//", which would be taken as another host
+		"{path}", "/"+strings.TrimLeft(req.URL.EscapedPath(), "/"),
+		"{query}", req.URL.Query().Encode(),
+		"{ip}", url.QueryEscape(ip),
+	).Replace(target)
+
+	parsed, err := url.Parse(location)
+	if err != nil {
+		return "", err
+	}
+	return location, verifyRedirectTarget(target, host, parsed)
+}
+
+// verifyRedirectTarget checks that the expanded URL points to the host of
+// the redirect URL (or the request host for {host}).
+func verifyRedirectTarget(target, requestHost string, location *url.URL) error {
+	scheme, host, absolute := splitRedirectURL(target)
+	if !absolute {
+		if len(location.Scheme) != 0 || len(location.Host) != 0 || !isLocalPath(location.String()) {
+			return fmt.Errorf("redirect [%s] leaves the host", location)
+		}
+		return nil
+	}
+
+	if host == "{host}" {
+		host = requestHost
+	}
+	if location.Scheme != scheme || location.Host != host {
+		return fmt.Errorf("redirect [%s] does not point to [%s://%s]", location, scheme, host)
+	}
+	return nil
+}
+
+// isValidHost reports whether the Host header is a host name or IP address
+// with an optional port.
+func isValidHost(host string) bool {
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		if len(port) == 0 || strings.Trim(port, "0123456789") != "" {
+			return false
+		}
+		host = h
+	}
+	if net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")) != nil {
+		return true
+	}
+	if len(host) == 0 || len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(host, ".") {
+		if len(label) == 0 || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return false
+		}
+		for _, r := range label {
+			if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
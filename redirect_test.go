@@ -0,0 +1,103 @@
+package geoblock_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	geoblock "github.com/PascalMinder/geoblock"
+)
+
+func createRedirectHandler(t *testing.T, cfg *geoblock.Config) http.Handler {
+	t.Helper()
+
+	cfg.Countries = append(cfg.Countries, "DE")
+	return createCountryAPIHandler(t, cfg, exampleCountries, nil)
+}
+
+func redirectRequest(handler http.Handler, target, ip string) *http.Response {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.Header.Add(xForwardedFor, ip)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder.Result()
+}
+
+func TestRedirectPlaceholders(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.RedirectURLIfDenied = "https://{host}/blocked/{country}{path}?ip={ip}&{query}"
+	cfg.RedirectStatusCode = http.StatusSeeOther
+
+	resp := redirectRequest(createRedirectHandler(t, cfg), "http://shop.example.com//evil.com/a%20b?x=1&y=a%26b", caExampleIP)
+
+	assertStatusCode(t, resp, http.StatusSeeOther)
+	assertResponseHeader(t, resp, "Location",
+		"https://shop.example.com/blocked/CA/evil.com/a%20b?ip="+caExampleIP+"&x=1&y=a%26b")
+}
+
+func TestRedirectByCountry(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.RedirectURLIfDenied = "https://example.com/blocked"
+	cfg.RedirectURLsByCountry = map[string]string{
+		"ch": "https://example.ch{path}",
+		"US": "/us",
+	}
+	cfg.RedirectStatusCode = http.StatusTemporaryRedirect
+
+	handler := createRedirectHandler(t, cfg)
+
+	resp := redirectRequest(handler, "http://localhost/products/1", chExampleIP)
+	assertStatusCode(t, resp, http.StatusTemporaryRedirect)
+	assertResponseHeader(t, resp, "Location", "https://example.ch/products/1")
+
+	resp = redirectRequest(handler, "http://localhost/products/1", caExampleIP)
+	assertResponseHeader(t, resp, "Location", "https://example.com/blocked")
+}
+
+func TestRedirectOnlyForConfiguredCountries(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.RedirectURLsByCountry = map[string]string{"CH": "https://example.ch"}
+
+	handler := createRedirectHandler(t, cfg)
+
+	assertStatusCode(t, redirectRequest(handler, "http://localhost", chExampleIP), http.StatusFound)
+	assertStatusCode(t, redirectRequest(handler, "http://localhost", caExampleIP), http.StatusForbidden)
+}
+
+func TestRedirectWithInvalidHostIsDenied(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.RedirectURLIfDenied = "https://{host}/blocked"
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.Host = "evil.com/phishing?"
+	req.Header.Add(xForwardedFor, caExampleIP)
+	recorder := httptest.NewRecorder()
+	createRedirectHandler(t, cfg).ServeHTTP(recorder, req)
+
+	assertStatusCode(t, recorder.Result(), http.StatusForbidden)
+	assertResponseHeader(t, recorder.Result(), "Location", "")
+}
+
+func TestInvalidRedirectConfig(t *testing.T) {
+	for name, modify := range map[string]func(cfg *geoblock.Config){
+		"status code":         func(cfg *geoblock.Config) { cfg.RedirectStatusCode = http.StatusOK },
+		"unknown placeholder": func(cfg *geoblock.Config) { cfg.RedirectURLIfDenied = "https://example.com/{city}" },
+		"placeholder in host": func(cfg *geoblock.Config) { cfg.RedirectURLIfDenied = "https://{country}.example.com" },
+		"placeholder as host": func(cfg *geoblock.Config) { cfg.RedirectURLIfDenied = "https://{path}" },
+		"protocol relative":   func(cfg *geoblock.Config) { cfg.RedirectURLIfDenied = "//example.com" },
+		"backslash":           func(cfg *geoblock.Config) { cfg.RedirectURLIfDenied = `/\example.com` },
+		"scheme":              func(cfg *geoblock.Config) { cfg.RedirectURLIfDenied = "javascript://alert(1)" },
+		"relative":            func(cfg *geoblock.Config) { cfg.RedirectURLIfDenied = "blocked.html" },
+		"country code": func(cfg *geoblock.Config) {
+			cfg.RedirectURLsByCountry = map[string]string{"Germany": "https://example.de"}
+		},
+	} {
+		cfg := createTesterConfig()
+		cfg.Countries = append(cfg.Countries, "CH")
+		modify(cfg)
+
+		if _, err := newCountryAPIHandler(t, cfg, exampleCountries, nil); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package geoblock
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const minutesPerDay = 24 * 60
+
+// CountrySchedule adds countries to the countries list while the schedule is
+// active: on the weekdays, between the times of day in the time zone and
+// between the start and end timestamps. Unset parts do not restrict it.
+type CountrySchedule struct {
+	Countries []string `yaml:"countries,omitempty"`
+	TimeZone  string   `yaml:"timeZone"`           // IANA name, e.g. "Europe/Zurich"; default UTC
+	Weekdays  []string `yaml:"weekdays,omitempty"` // e.g. "mon" or "monday"
+	From      string   `yaml:"from"`               // time of day "15:04", inclusive
+	To        string   `yaml:"to"`                 // time of day "15:04" or "24:00", exclusive
+	Start     string   `yaml:"start"`              // RFC 3339 timestamp, inclusive
+	End       string   `yaml:"end"`                // RFC 3339 timestamp, exclusive
+}
+
+// countrySchedule is a parsed CountrySchedule.
+type countrySchedule struct {
+	countries []string
+	location  *time.Location
+	weekdays  [7]bool // indexed by time.Weekday
+	from, to  int     // minutes of the day; both 0 if the whole day
+	start     time.Time
+	end       time.Time
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+}
+
+func validateScheduleConfig(config *Config) []error {
+	var errs []error
+	for i, schedule := range config.CountrySchedules {
+		if _, err := parseCountrySchedule(schedule); err != nil {
+			errs = append(errs, fmt.Errorf("country schedule %d: %w", i+1, err))
+		}
+	}
+	return errs
+}
+
+func printScheduleConfiguration(name string, config *Config, logger *log.Logger) {
+	for _, schedule := range config.CountrySchedules {
+		logger.Printf("%s: Country schedule: %v %v %s-%s %s [%s, %s)", name, schedule.Countries,
+			schedule.Weekdays, schedule.From, schedule.To, schedule.TimeZone, schedule.Start, schedule.End)
+	}
+}
+
+func buildCountrySchedules(config *Config) ([]*countrySchedule, error) {
+	schedules := make([]*countrySchedule, 0, len(config.CountrySchedules))
+	for i, schedule := range config.CountrySchedules {
+		parsed, err := parseCountrySchedule(schedule)
+		if err != nil {
+			return nil, fmt.Errorf("country schedule %d: %w", i+1, err)
+		}
+		schedules = append(schedules, parsed)
+	}
+	return schedules, nil
+}
+
+func parseCountrySchedule(schedule CountrySchedule) (*countrySchedule, error) {
+	if len(schedule.Countries) == 0 {
+		return nil, fmt.Errorf("no countries")
+	}
+	parsed := &countrySchedule{location: time.UTC}
+	for _, country := range schedule.Countries {
+		if !isCountryCode(country) && !isSubdivisionCode(country) {
+			return nil, fmt.Errorf("invalid country code [%s]", country)
+		}
+		parsed.countries = append(parsed.countries, country)
+	}
+
+	if len(schedule.TimeZone) != 0 {
+		location, err := time.LoadLocation(schedule.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time zone [%s]: %w", schedule.TimeZone, err)
+		}
+		parsed.location = location
+	}
+
+	for _, name := range schedule.Weekdays {
+		weekday, ok := weekdayNames[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("invalid weekday [%s]", name)
+		}
+		parsed.weekdays[weekday] = true
+	}
+	if len(schedule.Weekdays) == 0 {
+		parsed.weekdays = [7]bool{true, true, true, true, true, true, true}
+	}
+
+	if err := parsed.parseTimesOfDay(schedule.From, schedule.To); err != nil {
+		return nil, err
+	}
+	if err := parsed.parseTimestamps(schedule.Start, schedule.End); err != nil {
+		return nil, err
+	}
+
+	return parsed, nil
+}
+
+func (s *countrySchedule) parseTimesOfDay(from, to string) error {
+	if len(from) == 0 && len(to) == 0 {
+		return nil
+	}
+	if len(from) == 0 || len(to) == 0 {
+		return fmt.Errorf("from and to must be set together")
+	}
+
+	var err error
+	if s.from, err = parseTimeOfDay(from); err != nil || s.from == minutesPerDay {
+		return fmt.Errorf("invalid from [%s], must be a time of day like 08:30", from)
+	}
+	if s.to, err = parseTimeOfDay(to); err != nil {
+		return fmt.Errorf("invalid to [%s], must be a time of day like 18:00 or 24:00", to)
+	}
+	if s.from == s.to {
+		return fmt.Errorf("from and to must differ")
+	}
+	return nil
+}
+
+// parseTimeOfDay returns the minutes of the day of "15:04"; "24:00" is the
+// end of the day.
+func parseTimeOfDay(value string) (int, error) {
+	hours, minutes, ok := strings.Cut(value, ":")
+	if !ok || len(hours) != 2 || len(minutes) != 2 {
+		return 0, fmt.Errorf("invalid time of day [%s]", value)
+	}
+	h, err := strconv.Atoi(hours)
+	if err != nil {
+		return 0, err
+	}
+	m, err := strconv.Atoi(minutes)
+	if err != nil {
+		return 0, err
+	}
+	if h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time of day [%s]", value)
+	}
+	return h*60 + m, nil
+}
+
+func (s *countrySchedule) parseTimestamps(start, end string) error {
+	var err error
+	if len(start) != 0 {
+		if s.start, err = time.Parse(time.RFC3339, start); err != nil {
+			return fmt.Errorf("invalid start [%s], must be an RFC 3339 timestamp", start)
+		}
+	}
+	if len(end) != 0 {
+		if s.end, err = time.Parse(time.RFC3339, end); err != nil {
+			return fmt.Errorf("invalid end [%s], must be an RFC 3339 timestamp", end)
+		}
+	}
+	if !s.start.IsZero() && !s.end.IsZero() && !s.end.After(s.start) {
+		return fmt.Errorf("end [%s] must be after start [%s]", end, start)
+	}
+	return nil
+}
+
+// active reports whether the schedule is active at the instant. The times of
+// day are wall clock times in the time zone, so a window keeps its local
+// hours across daylight saving time changes. A window ending before it
+// starts, e.g. 22:00 to 06:00, runs past midnight; its early hours belong to
+// the weekday it started on.
+func (s *countrySchedule) active(now time.Time) bool {
+	if (!s.start.IsZero() && now.Before(s.start)) || (!s.end.IsZero() && !now.Before(s.end)) {
+		return false
+	}
+
+	local := now.In(s.location)
+	weekday := local.Weekday()
+	if s.from == s.to {
+		return s.weekdays[weekday]
+	}
+
+	minute := local.Hour()*60 + local.Minute()
+	if s.from < s.to {
+		return s.weekdays[weekday] && minute >= s.from && minute < s.to
+	}
+
+	if minute >= s.from {
+		return s.weekdays[weekday]
+	}
+	previous := (weekday + 6) % 7
+	return s.weekdays[previous] && minute < s.to
+}
+
+// scheduledCountry reports whether the country or subdivision is listed by
+// an active schedule.
+func (a *GeoBlock) scheduledCountry(country, subdivision string) bool {
+	if len(a.schedules) == 0 {
+		return false
+	}
+
+	now := a.clock()
+	for _, schedule := range a.schedules {
+		listed := stringInSlice(country, schedule.countries) ||
+			(len(subdivision) > 0 && stringInSlice(subdivision, schedule.countries))
+		if listed && schedule.active(now) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+package geoblock
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func mustParseSchedule(t *testing.T, schedule CountrySchedule) *countrySchedule {
+	t.Helper()
+
+	parsed, err := parseCountrySchedule(schedule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func mustParseTime(t *testing.T, value string) time.Time {
+	t.Helper()
+
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestScheduleBusinessHours(t *testing.T) {
+	schedule := mustParseSchedule(t, CountrySchedule{
+		Countries: []string{"DE"},
+		TimeZone:  "Europe/Zurich",
+		Weekdays:  []string{"mon", "Tue", "wednesday", "thu", "fri"},
+		From:      "08:00",
+		To:        "18:00",
+	})
+
+	for instant, expected := range map[string]bool{
+		"2026-10-19T07:59:59+02:00": false, // Monday
+		"2026-10-19T08:00:00+02:00": true,
+		"2026-10-19T17:59:59+02:00": true,
+		"2026-10-19T18:00:00+02:00": false,
+		"2026-10-19T06:30:00Z":      true,  // 08:30 in Zurich
+		"2026-10-24T10:00:00+02:00": false, // Saturday
+		"2026-12-21T07:30:00Z":      true,  // winter time, 08:30 in Zurich
+		"2026-12-21T06:30:00Z":      false,
+	} {
+		if active := schedule.active(mustParseTime(t, instant)); active != expected {
+			t.Errorf("%s: expected active %t, got %t", instant, expected, active)
+		}
+	}
+}
+
+func TestScheduleAcrossMidnight(t *testing.T) {
+	schedule := mustParseSchedule(t, CountrySchedule{
+		Countries: []string{"DE"},
+		Weekdays:  []string{"fri"},
+		From:      "22:00",
+		To:        "06:00",
+	})
+
+	for instant, expected := range map[string]bool{
+		"2026-10-23T21:59:00Z": false, // Friday
+		"2026-10-23T22:00:00Z": true,
+		"2026-10-24T05:59:00Z": true, // Saturday, still Friday night
+		"2026-10-24T06:00:00Z": false,
+		"2026-10-24T22:30:00Z": false,
+		"2026-10-23T03:00:00Z": false, // Friday, but Thursday night
+	} {
+		if active := schedule.active(mustParseTime(t, instant)); active != expected {
+			t.Errorf("%s: expected active %t, got %t", instant, expected, active)
+		}
+	}
+}
+
+func TestScheduleDaylightSavingTime(t *testing.T) {
+	schedule := mustParseSchedule(t, CountrySchedule{
+		Countries: []string{"DE"},
+		TimeZone:  "Europe/Zurich",
+		From:      "01:00",
+		To:        "03:00",
+	})
+
+	for instant, expected := range map[string]bool{
+		// spring forward: 02:00 becomes 03:00, the window lasts one hour
+		"2026-03-29T00:00:00Z": true,  // 01:00 CET
+		"2026-03-29T00:59:59Z": true,  // 01:59:59 CET
+		"2026-03-29T01:00:00Z": false, // 03:00 CEST
+		// fall back: 03:00 becomes 02:00, the window lasts three hours
+		"2026-10-24T23:00:00Z": true,  // 01:00 CEST
+		"2026-10-25T00:30:00Z": true,  // 02:30 CEST
+		"2026-10-25T01:30:00Z": true,  // 02:30 CET
+		"2026-10-25T02:00:00Z": false, // 03:00 CET
+	} {
+		if active := schedule.active(mustParseTime(t, instant)); active != expected {
+			t.Errorf("%s: expected active %t, got %t", instant, expected, active)
+		}
+	}
+}
+
+func TestScheduleStartAndEnd(t *testing.T) {
+	schedule := mustParseSchedule(t, CountrySchedule{
+		Countries: []string{"DE"},
+		Start:     "2026-11-01T09:00:00+01:00",
+		End:       "2026-11-03T18:00:00+01:00",
+	})
+
+	for instant, expected := range map[string]bool{
+		"2026-11-01T07:59:59Z": false,
+		"2026-11-01T08:00:00Z": true,
+		"2026-11-03T16:59:59Z": true,
+		"2026-11-03T17:00:00Z": false,
+	} {
+		if active := schedule.active(mustParseTime(t, instant)); active != expected {
+			t.Errorf("%s: expected active %t, got %t", instant, expected, active)
+		}
+	}
+}
+
+func TestScheduledCountryDecision(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		_, _ = rw.Write([]byte("DE"))
+	}))
+	defer server.Close()
+
+	config := CreateConfig()
+	config.API = server.URL + "/{ip}"
+	config.CacheSize = 10
+	config.SilentStartUp = true
+	config.CountrySchedules = []CountrySchedule{
+		{Countries: []string{"DE"}, TimeZone: "Europe/Berlin", From: "09:00", To: "17:00"},
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}),
+		config, t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	geoBlock := handler.(*GeoBlock)
+
+	for instant, expected := range map[string]int{
+		"2026-10-19T08:59:00+02:00": http.StatusForbidden,
+		"2026-10-19T09:00:00+02:00": http.StatusOK,
+		"2026-10-19T17:00:00+02:00": http.StatusForbidden,
+	} {
+		now := mustParseTime(t, instant)
+		geoBlock.clock = func() time.Time { return now }
+
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.Header.Set("X-Forwarded-For", "82.220.110.18")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != expected {
+			t.Errorf("%s: expected status %d, got %d", instant, expected, recorder.Code)
+		}
+	}
+}
+
+func TestInvalidCountrySchedules(t *testing.T) {
+	for name, schedule := range map[string]CountrySchedule{
+		"no countries":    {From: "08:00", To: "18:00"},
+		"country code":    {Countries: []string{"Germany"}},
+		"time zone":       {Countries: []string{"DE"}, TimeZone: "Europe/Atlantis"},
+		"weekday":         {Countries: []string{"DE"}, Weekdays: []string{"funday"}},
+		"from without to": {Countries: []string{"DE"}, From: "08:00"},
+		"time of day":     {Countries: []string{"DE"}, From: "8:00", To: "18:00"},
+		"hour":            {Countries: []string{"DE"}, From: "08:00", To: "25:00"},
+		"equal times":     {Countries: []string{"DE"}, From: "08:00", To: "08:00"},
+		"start":           {Countries: []string{"DE"}, Start: "2026-11-01"},
+		"end before start": {
+			Countries: []string{"DE"}, Start: "2026-11-02T00:00:00Z", End: "2026-11-01T00:00:00Z",
+		},
+	} {
+		if errs := validateScheduleConfig(&Config{CountrySchedules: []CountrySchedule{schedule}}); len(errs) == 0 {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
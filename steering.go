@@ -0,0 +1,178 @@
+package geoblock
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	continentHeader    = "X-IPContinent"
+	euHeader           = "X-IPEU"
+	countryGroupHeader = "X-IPCountryGroup"
+)
+
+// continentCountries lists the countries of each continent code, as used by
+// the GeoNames and MaxMind databases.
+var continentCountries = map[string]string{
+	"AF": "AO BF BI BJ BW CD CF CG CI CM CV DJ DZ EG EH ER ET GA GH GM GN GQ GW KE KM LR LS LY MA MG ML MR MU MW " +
+		"MZ NA NE NG RE RW SC SD SH SL SN SO SS ST SZ TD TG TN TZ UG YT ZA ZM ZW",
+	"AN": "AQ BV GS HM TF",
+	"AS": "AE AF AM AZ BD BH BN BT CC CN CX GE HK ID IL IN IO IQ IR JO JP KG KH KP KR KW KZ LA LB LK MM MN MO MV " +
+		"MY NP OM PH PK PS QA SA SG SY TH TJ TL TM TR TW UZ VN YE",
+	"EU": "AD AL AT AX BA BE BG BY CH CY CZ DE DK EE ES FI FO FR GB GG GI GR HR HU IE IM IS IT JE LI LT LU LV MC " +
+		"MD ME MK MT NL NO PL PT RO RS RU SE SI SJ SK SM UA VA XK",
+	"NA": "AG AI AW BB BL BM BQ BS BZ CA CR CU CW DM DO GD GL GP GT HN HT JM KN KY LC MF MQ MS MX NI PA PM PR SV " +
+		"SX TC TT US VC VG VI",
+	"OC": "AS AU CK FJ FM GU KI MH MP NC NF NR NU NZ PF PG PN PW SB TK TO TV UM VU WF WS",
+	"SA": "AR BO BR CL CO EC FK GF GY PE PY SR UY VE",
+}
+
+// euMembers are the member states of the European Union.
+const euMembers = "AT BE BG CY CZ DE DK EE ES FI FR GR HR HU IE IT LT LU LV MT NL PL PT RO SE SI SK"
+
+var (
+	continents  = buildContinents()
+	euCountries = buildCountrySet(euMembers)
+)
+
+func buildContinents() map[string]string {
+	byCountry := make(map[string]string)
+	for continent, countries := range continentCountries {
+		for _, country := range strings.Fields(countries) {
+			byCountry[country] = continent
+		}
+	}
+	return byCountry
+}
+
+func buildCountrySet(countries string) map[string]bool {
+	set := make(map[string]bool)
+	for _, country := range strings.Fields(countries) {
+		set[country] = true
+	}
+	return set
+}
+
+func validateSteeringConfig(config *Config) []error {
+	var errs []error
+
+	for code, group := range config.CountryGroups {
+		if upper := strings.ToUpper(code); !isCountryCode(upper) && !isSubdivisionCode(upper) {
+			errs = append(errs, fmt.Errorf("country groups: invalid country code [%s]", code))
+		}
+		if !isGroupName(group) {
+			errs = append(errs, fmt.Errorf("country groups: invalid group name [%s] for [%s]", group, code))
+		}
+	}
+
+	if config.AddCountryGroupHeader && len(config.CountryGroups) == 0 {
+		errs = append(errs, fmt.Errorf("addCountryGroupHeader requires countryGroups"))
+	}
+
+	return errs
+}
+
+func printSteeringConfiguration(name string, config *Config, logger *log.Logger) {
+	logger.Printf("%s: steering mode: %t", name, config.SteeringMode)
+	logger.Printf("%s: add country header: %t", name, config.AddCountryHeader)
+	logger.Printf("%s: add continent header: %t", name, config.AddContinentHeader)
+	logger.Printf("%s: add EU header: %t", name, config.AddEUHeader)
+	if len(config.CountryGroups) > 0 {
+		logger.Printf("%s: Country groups: %v", name, config.CountryGroups)
+	}
+	logger.Printf("%s: add country group header: %t", name, config.AddCountryGroupHeader)
+}
+
+// isGroupName reports whether the group name can be used as header value
+// and in Traefik rules: letters, digits, '-', '_' and '.'.
+func isGroupName(group string) bool {
+	if len(group) == 0 {
+		return false
+	}
+	for _, r := range group {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '-' && r != '_' && r != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeCountryGroups upper-cases the country codes.
+func normalizeCountryGroups(groups map[string]string) map[string]string {
+	normalized := make(map[string]string, len(groups))
+	for code, group := range groups {
+		normalized[strings.ToUpper(code)] = group
+	}
+	return normalized
+}
+
+// countryGroup returns the group of the subdivision or, if it has none, of
+// the country of the entry.
+func (a *GeoBlock) countryGroup(entry ipEntry) (string, bool) {
+	if group, ok := a.countryGroups[entry.Subdivision]; ok && len(entry.Subdivision) > 0 {
+		return group, true
+	}
+	group, ok := a.countryGroups[entry.Country]
+	return group, ok
+}
+
+// addDerivedHeaders adds the configured continent, EU membership and country
+// group request headers of a known country.
+func (a *GeoBlock) addDerivedHeaders(req *http.Request, entry ipEntry) {
+	continent, known := continents[entry.Country]
+	if a.addContinentHeader && known {
+		req.Header.Set(continentHeader, continent)
+	}
+	if a.addEUHeader && known {
+		req.Header.Set(euHeader, strconv.FormatBool(euCountries[entry.Country]))
+	}
+	if a.addCountryGroupHeader {
+		if group, ok := a.countryGroup(entry); ok {
+			req.Header.Set(countryGroupHeader, group)
+		}
+	}
+}
+
+// steer adds the routing headers of the client IP address and forwards the
+// request. In steering mode requests are never denied: if the country cannot
+// be determined, the request is forwarded without the headers.
+func (a *GeoBlock) steer(rw http.ResponseWriter, req *http.Request, trace *explainTrace) {
+	// the service relies on the headers, never forward the ones sent by the client
+	for _, header := range []string{countryHeader, asnHeader, regionHeader, continentHeader, euHeader, countryGroupHeader} {
+		req.Header.Del(header)
+	}
+
+	requestIPAddresses, err := a.collectRemoteIP(req)
+	switch {
+	case err != nil:
+		a.infoLogger.Printf("%s: no routing headers added: %s", a.name, err)
+	case len(requestIPAddresses) == 0:
+		if a.logAPIRequests {
+			a.infoLogger.Printf("%s: no routing headers added: no client IP address", a.name)
+		}
+	default:
+		// the first IP address is the client, if the proxies behave
+		requestIPAddress := requestIPAddresses[0]
+		trace.collected(requestIPAddresses, requestIPAddresses[:1])
+		trace.beginIP(requestIPAddress)
+
+		if isPrivateIP(*requestIPAddress, a.privateIPRanges) {
+			trace.local()
+		} else if ok, entry := a.cachedRequestIP(requestIPAddress, req); ok {
+			trace.details(entry)
+			a.addEntryHeaders(req, entry)
+		} else if a.logAPIRequests {
+			a.infoLogger.Printf("%s: no routing headers added for [%s]: country lookup failed", a.name, requestIPAddress)
+		}
+		trace.decide(true, reasonSteering)
+	}
+
+	trace.finish(true, reasonSteering)
+	if trace.write(rw) {
+		return
+	}
+	a.next.ServeHTTP(rw, req)
+}
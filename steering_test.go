@@ -0,0 +1,143 @@
+package geoblock_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	geoblock "github.com/PascalMinder/geoblock"
+)
+
+const (
+	continentHeader    = "X-IPContinent"
+	euHeader           = "X-IPEU"
+	countryGroupHeader = "X-IPCountryGroup"
+)
+
+// createSteeringHandler creates the middleware in steering mode, with the
+// API answering the country for chExampleIP.
+func createSteeringHandler(t *testing.T, cfg *geoblock.Config, country string) (http.Handler, *bool) {
+	t.Helper()
+
+	cfg.SteeringMode = true
+
+	forwarded := false
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) { forwarded = true })
+
+	return createCountryAPIHandler(t, cfg, map[string]string{chExampleIP: country}, next), &forwarded
+}
+
+func steeringRequest(handler http.Handler, ip string) (*http.Request, *http.Response) {
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.Header.Add(xForwardedFor, ip)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return req, recorder.Result()
+}
+
+func TestSteeringModeAddsHeaders(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.AddContinentHeader = true
+	cfg.AddEUHeader = true
+	cfg.AddCountryGroupHeader = true
+	cfg.CountryGroups = map[string]string{"de": "dach", "CH": "dach", "AT": "dach", "FR": "west"}
+
+	for country, expected := range map[string][3]string{
+		"CH": {"EU", "false", "dach"},
+		"DE": {"EU", "true", "dach"},
+		"FR": {"EU", "true", "west"},
+		"CA": {"NA", "false", ""},
+		"JP": {"AS", "false", ""},
+	} {
+		t.Run(country, func(t *testing.T) {
+			handler, forwarded := createSteeringHandler(t, cfg, country)
+
+			req, resp := steeringRequest(handler, chExampleIP)
+
+			assertStatusCode(t, resp, http.StatusOK)
+			if !*forwarded {
+				t.Fatal("expected request to be forwarded")
+			}
+			assertRequestHeader(t, req, CountryHeader, country)
+			assertRequestHeader(t, req, continentHeader, expected[0])
+			assertRequestHeader(t, req, euHeader, expected[1])
+			assertRequestHeader(t, req, countryGroupHeader, expected[2])
+		})
+	}
+}
+
+func TestSteeringModeNeverDenies(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.Countries = append(cfg.Countries, "CH")
+	cfg.BlackListMode = true
+	cfg.DeniedIPAddresses = []string{chExampleIP}
+
+	handler, forwarded := createSteeringHandler(t, cfg, "CH")
+
+	req, resp := steeringRequest(handler, chExampleIP)
+
+	assertStatusCode(t, resp, http.StatusOK)
+	if !*forwarded {
+		t.Fatal("expected request to be forwarded")
+	}
+	assertRequestHeader(t, req, CountryHeader, "CH")
+}
+
+func TestSteeringModeRemovesClientHeaders(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.AddContinentHeader = true
+	cfg.AllowLocalRequests = true
+
+	handler, forwarded := createSteeringHandler(t, cfg, "CH")
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.Header.Add(xForwardedFor, "192.168.1.1")
+	req.Header.Set(CountryHeader, "US")
+	req.Header.Set(continentHeader, "NA")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	assertStatusCode(t, recorder.Result(), http.StatusOK)
+	if !*forwarded {
+		t.Fatal("expected request to be forwarded")
+	}
+	assertRequestHeader(t, req, CountryHeader, "")
+	assertRequestHeader(t, req, continentHeader, "")
+}
+
+func TestDerivedHeadersInBlockingMode(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.Countries = append(cfg.Countries, "CH")
+	cfg.AddContinentHeader = true
+	cfg.AddEUHeader = true
+
+	handler := createCountryAPIHandler(t, cfg, exampleCountries, nil)
+
+	req, resp := steeringRequest(handler, chExampleIP)
+
+	assertStatusCode(t, resp, http.StatusOK)
+	assertRequestHeader(t, req, CountryHeader, "")
+	assertRequestHeader(t, req, continentHeader, "EU")
+	assertRequestHeader(t, req, euHeader, "false")
+}
+
+func TestInvalidSteeringConfig(t *testing.T) {
+	for name, modify := range map[string]func(cfg *geoblock.Config){
+		"countries without steering": func(cfg *geoblock.Config) { cfg.SteeringMode = false },
+		"invalid country code": func(cfg *geoblock.Config) {
+			cfg.CountryGroups = map[string]string{"Germany": "dach"}
+		},
+		"invalid group name": func(cfg *geoblock.Config) {
+			cfg.CountryGroups = map[string]string{"DE": "d a c h"}
+		},
+		"group header without groups": func(cfg *geoblock.Config) { cfg.AddCountryGroupHeader = true },
+	} {
+		cfg := createTesterConfig()
+		cfg.SteeringMode = true
+		modify(cfg)
+
+		if _, err := newCountryAPIHandler(t, cfg, exampleCountries, nil); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
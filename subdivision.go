@@ -0,0 +1,169 @@
+package geoblock
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/PascalMinder/geoblock/mmdb"
+)
+
+const (
+	regionHeader = "X-IPRegion"
+
+	maxSubdivisionCodeLength = 3
+)
+
+// subdivisionResolver resolves the ISO 3166-2 subdivision code of an IP
+// address, e.g. "US-CA". An empty code means unknown.
+type subdivisionResolver interface {
+	lookupSubdivision(ip net.IP, country string) (string, error)
+}
+
+// buildSubdivisionResolver creates the resolver for the configured
+// subdivision source, or nil if none is configured.
+func buildSubdivisionResolver(config *Config) (subdivisionResolver, error) {
+	switch {
+	case len(config.SubdivisionDatabasePath) != 0:
+		reader, err := mmdb.Open(config.SubdivisionDatabasePath)
+		if err != nil {
+			return nil, fmt.Errorf("subdivision database: %w", err)
+		}
+		return &mmdbSubdivisionDatabase{reader: reader}, nil
+	case len(config.SubdivisionAPI) != 0:
+		return &subdivisionAPI{
+			uri:     config.SubdivisionAPI,
+			field:   config.SubdivisionAPIField,
+			timeout: time.Duration(config.APITimeoutMs) * time.Millisecond,
+		}, nil
+	default:
+		return nil, nil
+	}
+}
+
+func validateSubdivisionConfig(config *Config) []error {
+	var errs []error
+
+	hasDatabase, hasAPI := len(config.SubdivisionDatabasePath) != 0, len(config.SubdivisionAPI) != 0
+	switch {
+	case hasDatabase && hasAPI:
+		errs = append(errs, errors.New("subdivisionDatabasePath and subdivisionApi are mutually exclusive"))
+	case hasDatabase && !strings.EqualFold(filepath.Ext(config.SubdivisionDatabasePath), ".mmdb"):
+		errs = append(errs, fmt.Errorf("subdivision database [%s] must be a MaxMind DB (.mmdb)", config.SubdivisionDatabasePath))
+	case hasAPI && !strings.Contains(config.SubdivisionAPI, "{ip}"):
+		errs = append(errs, errors.New("subdivisionApi must contain the {ip} placeholder"))
+	case hasAPI && len(config.SubdivisionAPIField) == 0:
+		errs = append(errs, errors.New("subdivisionApi requires subdivisionApiField"))
+	case !hasDatabase && !hasAPI && config.AddRegionHeader:
+		errs = append(errs, errors.New("addRegionHeader configured without subdivisionDatabasePath or subdivisionApi"))
+	}
+
+	if !hasDatabase && !hasAPI {
+		for _, code := range config.Countries {
+			if isSubdivisionCode(code) {
+				errs = append(errs, fmt.Errorf(
+					"subdivision code [%s] configured without subdivisionDatabasePath or subdivisionApi", code))
+			}
+		}
+	}
+
+	return errs
+}
+
+// isSubdivisionCode reports whether the code is an ISO 3166-2 subdivision
+// code: a country code and up to three letters or digits, e.g. "CH-ZH".
+func isSubdivisionCode(code string) bool {
+	country, subdivision, ok := strings.Cut(code, "-")
+	if !ok || !isCountryCode(country) || len(subdivision) == 0 || len(subdivision) > maxSubdivisionCodeLength {
+		return false
+	}
+	for _, r := range subdivision {
+		if (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeSubdivision turns a subdivision code with or without the country
+// prefix (e.g. "CA" or "us-ca") into an ISO 3166-2 code.
+func normalizeSubdivision(country, code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if len(code) == 0 {
+		return "", nil
+	}
+	if !strings.Contains(code, "-") {
+		code = country + "-" + code
+	}
+	if !isSubdivisionCode(code) {
+		return "", fmt.Errorf("invalid subdivision code [%s]", code)
+	}
+	return code, nil
+}
+
+// mmdbSubdivisionDatabase resolves subdivisions from a MaxMind City database
+// (e.g. GeoLite2-City).
+type mmdbSubdivisionDatabase struct {
+	reader *mmdb.Reader
+}
+
+func (d *mmdbSubdivisionDatabase) lookupSubdivision(ip net.IP, country string) (string, error) {
+	record, ok, err := d.reader.Lookup(ip)
+	if err != nil || !ok {
+		return "", err
+	}
+
+	code, _ := mmdb.Value(record, "subdivisions", 0, "iso_code")
+	codeString, _ := code.(string)
+	if len(codeString) == 0 {
+		return "", nil
+	}
+
+	// the subdivision belongs to the country of the database record, which
+	// may differ from the country of the lookup, e.g. another database
+	recordCountry, _ := mmdb.Value(record, "country", "iso_code")
+	if recordCountryString, _ := recordCountry.(string); recordCountryString != country {
+		return "", nil
+	}
+	return normalizeSubdivision(country, codeString)
+}
+
+// subdivisionAPI resolves subdivisions from a JSON API, e.g.
+// "https://ipapi.example/{ip}/json" with the field "region_code".
+type subdivisionAPI struct {
+	uri     string
+	field   string
+	timeout time.Duration
+}
+
+func (a *subdivisionAPI) lookupSubdivision(ip net.IP, country string) (string, error) {
+	value, err := fetchJSONField(a.uri, a.field, a.timeout, ip)
+	if err != nil {
+		return "", fmt.Errorf("subdivision API: %w", err)
+	}
+
+	code, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("subdivision API response: invalid subdivision [%v]", value)
+	}
+	return normalizeSubdivision(country, code)
+}
+
+// lookupSubdivision resolves the subdivision of the IP address in the
+// country. A failed lookup is logged and yields an empty code, so only the
+// country entries of the country rules apply to the request.
+func (a *GeoBlock) lookupSubdivision(ip net.IP, country string) string {
+	if a.subdivisionResolver == nil || country == unknownCountryCode {
+		return ""
+	}
+
+	subdivision, err := a.subdivisionResolver.lookupSubdivision(ip, country)
+	if err != nil {
+		a.infoLogger.Printf("%s: failed to resolve subdivision of [%s]: %v", a.name, ip, err)
+		return ""
+	}
+	return subdivision
+}
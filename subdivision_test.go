@@ -0,0 +1,173 @@
+package geoblock_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	geoblock "github.com/PascalMinder/geoblock"
+)
+
+const regionHeader = "X-IPRegion"
+
+// createSubdivisionHandler serves the country US and the subdivision of the
+// last IP address byte: 1 is California, 2 is New York, others are unknown.
+func createSubdivisionHandler(t *testing.T, cfg *geoblock.Config) http.Handler {
+	t.Helper()
+
+	countryServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		_, _ = rw.Write([]byte("US"))
+	}))
+	t.Cleanup(countryServer.Close)
+
+	subdivisionServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch {
+		case strings.HasSuffix(req.URL.Path, ".1"):
+			_, _ = rw.Write([]byte(`{"region": {"code": "CA", "name": "California"}}`))
+		case strings.HasSuffix(req.URL.Path, ".2"):
+			_, _ = rw.Write([]byte(`{"region": {"code": "us-ny", "name": "New York"}}`))
+		default:
+			_, _ = rw.Write([]byte(`{"region": {"code": ""}}`))
+		}
+	}))
+	t.Cleanup(subdivisionServer.Close)
+
+	cfg.API = countryServer.URL + "/{ip}"
+	cfg.SubdivisionAPI = subdivisionServer.URL + "/{ip}"
+	cfg.SubdivisionAPIField = "region.code"
+
+	ctx := context.Background()
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+
+	handler, err := geoblock.New(ctx, next, cfg, t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return handler
+}
+
+func TestAllowedSubdivision(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.Countries = append(cfg.Countries, "US-CA")
+
+	handler := createSubdivisionHandler(t, cfg)
+
+	assertStatusCode(t, geoblockRequest(handler, "192.0.2.1"), http.StatusOK)
+	assertStatusCode(t, geoblockRequest(handler, "192.0.2.2"), http.StatusForbidden)
+	assertStatusCode(t, geoblockRequest(handler, "192.0.2.3"), http.StatusForbidden)
+}
+
+func TestUnknownSubdivisionFallsBackToCountry(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.Countries = append(cfg.Countries, "US", "CH-ZH")
+
+	handler := createSubdivisionHandler(t, cfg)
+
+	assertStatusCode(t, geoblockRequest(handler, "192.0.2.2"), http.StatusOK)
+	assertStatusCode(t, geoblockRequest(handler, "192.0.2.3"), http.StatusOK)
+}
+
+func TestDeniedSubdivisionInBlacklistMode(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.BlackListMode = true
+	cfg.Countries = append(cfg.Countries, "US-NY")
+
+	handler := createSubdivisionHandler(t, cfg)
+
+	assertStatusCode(t, geoblockRequest(handler, "192.0.2.1"), http.StatusOK)
+	assertStatusCode(t, geoblockRequest(handler, "192.0.2.2"), http.StatusForbidden)
+	assertStatusCode(t, geoblockRequest(handler, "192.0.2.3"), http.StatusOK)
+}
+
+func TestRegionHeader(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.Countries = append(cfg.Countries, "US")
+	cfg.AddCountryHeader = true
+	cfg.AddRegionHeader = true
+
+	handler := createSubdivisionHandler(t, cfg)
+
+	for ip, expected := range map[string]string{"192.0.2.1": "US-CA", "192.0.2.2": "US-NY", "192.0.2.3": ""} {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.Header.Add(xForwardedFor, ip)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		assertStatusCode(t, recorder.Result(), http.StatusOK)
+		assertRequestHeader(t, req, CountryHeader, "US")
+		assertRequestHeader(t, req, regionHeader, expected)
+	}
+}
+
+func TestInvalidSubdivisionConfig(t *testing.T) {
+	for name, modify := range map[string]func(cfg *geoblock.Config){
+		"invalid code":        func(cfg *geoblock.Config) { cfg.Countries = []string{"US-CALI"} },
+		"code without source": func(cfg *geoblock.Config) { cfg.Countries = []string{"US-CA"} },
+		"header without source": func(cfg *geoblock.Config) {
+			cfg.AddRegionHeader = true
+		},
+		"not a MaxMind DB": func(cfg *geoblock.Config) { cfg.SubdivisionDatabasePath = "regions.csv" },
+		"api without field": func(cfg *geoblock.Config) {
+			cfg.SubdivisionAPI = "https://ipapi.example/{ip}"
+		},
+	} {
+		cfg := createTesterConfig()
+		cfg.Countries = append(cfg.Countries, "US")
+		modify(cfg)
+
+		ctx := context.Background()
+		next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+
+		if _, err := geoblock.New(ctx, next, cfg, t.Name()); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestCountryOverrideHasNoSubdivision(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.Countries = append(cfg.Countries, "CH-CA")
+	cfg.AddCountryHeader = true
+	cfg.AddRegionHeader = true
+	cfg.CountryOverrides = map[string]string{"192.0.2.0/24": "CH"}
+
+	handler := createSubdivisionHandler(t, cfg)
+
+	// the subdivision API knows California, which belongs to the US
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.Header.Add(xForwardedFor, "192.0.2.1")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	assertStatusCode(t, recorder.Result(), http.StatusForbidden)
+	assertRequestHeader(t, req, CountryHeader, "CH")
+	assertRequestHeader(t, req, regionHeader, "")
+}
+
+func TestAdminAPIShowsSubdivision(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.Countries = append(cfg.Countries, "US-CA")
+	cfg.AdminAPIPath = adminAPIPath
+	cfg.AdminAPIToken = adminAPIToken
+
+	handler := createSubdivisionHandler(t, cfg)
+
+	assertStatusCode(t, geoblockRequest(handler, "192.0.2.1"), http.StatusOK)
+
+	resp := adminRequest(handler, http.MethodGet, "?ip=192.0.2.1", "127.0.0.1:4711", adminAPIToken)
+	assertStatusCode(t, resp, http.StatusOK)
+
+	var entry struct {
+		Country     string `json:"country"`
+		Subdivision string `json:"subdivision"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&entry); err != nil {
+		t.Fatalf("decode entry failed: %v", err)
+	}
+	if entry.Country != "US" || entry.Subdivision != "US-CA" {
+		t.Fatalf("unexpected cached entry: %+v", entry)
+	}
+}
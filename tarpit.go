@@ -0,0 +1,108 @@
+package geoblock
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultTarpitMaxConnections = 100
+	maxTarpitDelay              = 5 * time.Minute
+)
+
+// tarpit holds back the denied responses of abusive clients. At most max
+// requests are held at a time, further ones are answered right away, so the
+// tarpit cannot exhaust the resources of Traefik.
+type tarpit struct {
+	active atomic.Int64
+	max    atomic.Int64
+}
+
+var (
+	sharedTarpitsMu sync.Mutex
+	sharedTarpits   = map[string]*tarpit{}
+)
+
+func validateTarpit(index int, policy CountryPolicy) []error {
+	var errs []error
+
+	if policy.DelayMs <= 0 {
+		errs = append(errs, fmt.Errorf("country policy %d: tarpit requires a positive delayMs", index))
+	}
+	if policy.JitterMs < 0 {
+		errs = append(errs, fmt.Errorf("country policy %d: invalid jitterMs [%d]", index, policy.JitterMs))
+	}
+	if time.Duration(policy.DelayMs+policy.JitterMs)*time.Millisecond > maxTarpitDelay {
+		errs = append(errs, fmt.Errorf("country policy %d: tarpit delay must not exceed %s", index, maxTarpitDelay))
+	}
+
+	return errs
+}
+
+func validateTarpitConfig(config *Config) []error {
+	if config.TarpitMaxConnections < 0 {
+		return []error{fmt.Errorf("invalid tarpit max connections [%d]", config.TarpitMaxConnections)}
+	}
+	return nil
+}
+
+func printTarpitConfiguration(name string, config *Config, logger *log.Logger) {
+	if config.TarpitMaxConnections > 0 {
+		logger.Printf("%s: Tarpit max connections: %d", name, config.TarpitMaxConnections)
+	}
+}
+
+// buildTarpit returns the tarpit shared by the instances of the middleware,
+// so the limit of held requests applies to the middleware as a whole.
+func buildTarpit(config *Config, name string) *tarpit {
+	limit := int64(config.TarpitMaxConnections)
+	if limit == 0 {
+		limit = defaultTarpitMaxConnections
+	}
+
+	sharedTarpitsMu.Lock()
+	defer sharedTarpitsMu.Unlock()
+
+	t, ok := sharedTarpits[name]
+	if !ok {
+		t = &tarpit{}
+		sharedTarpits[name] = t
+	}
+	t.max.Store(limit)
+	return t
+}
+
+// tarpitDelay returns the delay of the policy plus a random jitter.
+func tarpitDelay(policy *CountryPolicy) time.Duration {
+	delay := time.Duration(policy.DelayMs) * time.Millisecond
+	if policy.JitterMs > 0 {
+		delay += time.Duration(rand.Int63n(int64(policy.JitterMs) * int64(time.Millisecond)))
+	}
+	return delay
+}
+
+// hold waits for the delay unless the limit of held requests is reached. It
+// returns false if the request was canceled meanwhile, e.g. the client went
+// away, and no response needs to be written.
+func (t *tarpit) hold(ctx context.Context, delay time.Duration) bool {
+	if t.active.Add(1) > t.max.Load() {
+		t.active.Add(-1)
+		return true
+	}
+	defer t.active.Add(-1)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,116 @@
+package geoblock_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	geoblock "github.com/PascalMinder/geoblock"
+)
+
+func createTarpitHandler(t *testing.T, cfg *geoblock.Config, delayMs int) http.Handler {
+	t.Helper()
+
+	cfg.Countries = append(cfg.Countries, "DE")
+	cfg.CountryPolicies = []geoblock.CountryPolicy{
+		{Countries: []string{"CA"}, Action: "tarpit", DelayMs: delayMs, JitterMs: 10},
+	}
+	return createCountryAPIHandler(t, cfg, exampleCountries, nil)
+}
+
+func tarpitRequest(ctx context.Context, handler http.Handler, ip string) (*httptest.ResponseRecorder, time.Duration) {
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil).WithContext(ctx)
+	req.Header.Add(xForwardedFor, ip)
+	recorder := httptest.NewRecorder()
+
+	start := time.Now()
+	handler.ServeHTTP(recorder, req)
+	return recorder, time.Since(start)
+}
+
+func TestTarpitDelaysDeniedResponse(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.DeniedResponseTemplate = `{{.Reason}}`
+
+	handler := createTarpitHandler(t, cfg, 100)
+
+	recorder, elapsed := tarpitRequest(context.Background(), handler, caExampleIP)
+
+	assertStatusCode(t, recorder.Result(), http.StatusForbidden)
+	if elapsed < 100*time.Millisecond {
+		t.Fatalf("expected response after at least 100ms, got %s", elapsed)
+	}
+	if body := recorder.Body.String(); body != "tarpit" {
+		t.Fatalf("expected reason tarpit, got %q", body)
+	}
+
+	// other countries are answered right away
+	recorder, elapsed = tarpitRequest(context.Background(), handler, chExampleIP)
+	assertStatusCode(t, recorder.Result(), http.StatusForbidden)
+	if elapsed >= 100*time.Millisecond {
+		t.Fatalf("expected immediate response, got %s", elapsed)
+	}
+}
+
+func TestTarpitStopsOnCanceledRequest(t *testing.T) {
+	handler := createTarpitHandler(t, createTesterConfig(), 10000)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	recorder, elapsed := tarpitRequest(ctx, handler, caExampleIP)
+
+	if elapsed >= time.Second {
+		t.Fatalf("expected the tarpit to stop with the request, took %s", elapsed)
+	}
+	if recorder.Body.Len() != 0 || len(recorder.Header()) != 0 {
+		t.Fatal("expected no response for a canceled request")
+	}
+}
+
+func TestTarpitMaxConnections(t *testing.T) {
+	cfg := createTesterConfig()
+	cfg.TarpitMaxConnections = 1
+
+	handler := createTarpitHandler(t, cfg, 10000)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tarpitRequest(ctx, handler, caExampleIP)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	recorder, elapsed := tarpitRequest(context.Background(), handler, caExampleIP)
+
+	cancel()
+	<-done
+
+	assertStatusCode(t, recorder.Result(), http.StatusForbidden)
+	if elapsed >= time.Second {
+		t.Fatalf("expected immediate response with the tarpit full, took %s", elapsed)
+	}
+}
+
+func TestInvalidTarpitConfig(t *testing.T) {
+	for name, modify := range map[string]func(cfg *geoblock.Config){
+		"no delay":        func(cfg *geoblock.Config) { cfg.CountryPolicies[0].DelayMs = 0 },
+		"negative jitter": func(cfg *geoblock.Config) { cfg.CountryPolicies[0].JitterMs = -1 },
+		"delay too long":  func(cfg *geoblock.Config) { cfg.CountryPolicies[0].DelayMs = 3600000 },
+		"max connections": func(cfg *geoblock.Config) { cfg.TarpitMaxConnections = -1 },
+	} {
+		cfg := createTesterConfig()
+		cfg.Countries = append(cfg.Countries, "CH")
+		cfg.CountryPolicies = []geoblock.CountryPolicy{
+			{Countries: []string{"CA"}, Action: "tarpit", DelayMs: 1000},
+		}
+		modify(cfg)
+
+		if _, err := newCountryAPIHandler(t, cfg, exampleCountries, nil); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package geoblock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	lru "github.com/PascalMinder/geoblock/lrucache"
+)
+
+const (
+	defaultVerifiedBotCacheSize = 1000
+	defaultVerifiedBotCacheTTL  = time.Hour
+	defaultVerifiedBotTimeout   = time.Second
+
+	// temporary DNS failures are cached briefly only, so a failing resolver
+	// is not asked on every request of a crawler
+	verifiedBotFailureTTL = time.Minute
+)
+
+// defaultVerifiedBotUserAgents are the user agent tokens of the Google and
+// Bing crawlers.
+var defaultVerifiedBotUserAgents = []string{
+	"Googlebot", "Google-InspectionTool", "GoogleOther", "AdsBot-Google", "Mediapartners-Google",
+	"Storebot-Google", "bingbot", "msnbot", "adidxbot", "BingPreview",
+}
+
+// botResolver performs the DNS lookups of the bot verification; implemented
+// by net.Resolver and replaced in tests.
+type botResolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// verifiedBots verifies search engine crawlers with forward-confirmed reverse
+// DNS: the PTR record of the IP address must end in one of the domains and
+// the A or AAAA records of that host name must contain the IP address again.
+// A user agent can be spoofed, the DNS records of the domains cannot.
+type verifiedBots struct {
+	domains    []string
+	userAgents []string // lower case
+	resolver   botResolver
+	results    *lru.LRUCache // IP address string -> botVerification
+	ttl        time.Duration
+	timeout    time.Duration
+	now        func() time.Time
+}
+
+// botVerification is the cached result of a verification.
+type botVerification struct {
+	host     string // verified host name, empty if not verified
+	verified bool
+	expires  time.Time
+}
+
+func validateVerifiedBotConfig(config *Config) []error {
+	var errs []error
+
+	for _, domain := range config.VerifiedBotDomains {
+		normalized := normalizeBotDomain(domain)
+		if !strings.Contains(normalized, ".") || strings.ContainsAny(normalized, " */:") {
+			errs = append(errs, fmt.Errorf("invalid verified bot domain [%s]", domain))
+		}
+	}
+	for _, userAgent := range config.VerifiedBotUserAgents {
+		if len(strings.TrimSpace(userAgent)) == 0 {
+			errs = append(errs, fmt.Errorf("empty verified bot user agent"))
+		}
+	}
+	if config.VerifiedBotCacheSize < 0 || config.VerifiedBotCacheSize == 1 {
+		errs = append(errs, fmt.Errorf("invalid verified bot cache size [%d], must be at least 2", config.VerifiedBotCacheSize))
+	}
+	if config.VerifiedBotCacheTTLSeconds < 0 {
+		errs = append(errs, fmt.Errorf("invalid verified bot cache TTL [%d] seconds", config.VerifiedBotCacheTTLSeconds))
+	}
+	if config.VerifiedBotTimeoutMs < 0 {
+		errs = append(errs, fmt.Errorf("invalid verified bot timeout [%d] ms", config.VerifiedBotTimeoutMs))
+	}
+
+	return errs
+}
+
+func printVerifiedBotConfiguration(name string, config *Config, logger *log.Logger) {
+	if len(config.VerifiedBotDomains) != 0 {
+		logger.Printf("%s: Verified bot domains: %v", name, config.VerifiedBotDomains)
+	}
+	if len(config.VerifiedBotUserAgents) != 0 {
+		logger.Printf("%s: Verified bot user agents: %v", name, config.VerifiedBotUserAgents)
+	}
+}
+
+// buildVerifiedBots returns the bot verification, or nil if no domains are
+// configured.
+func buildVerifiedBots(config *Config) (*verifiedBots, error) {
+	if len(config.VerifiedBotDomains) == 0 {
+		return nil, nil
+	}
+
+	size := config.VerifiedBotCacheSize
+	if size == 0 {
+		size = defaultVerifiedBotCacheSize
+	}
+	results, err := lru.NewLRUCache(size)
+	if err != nil {
+		return nil, fmt.Errorf("verified bot cache: %w", err)
+	}
+
+	bots := &verifiedBots{
+		resolver: net.DefaultResolver,
+		results:  results,
+		ttl:      defaultVerifiedBotCacheTTL,
+		timeout:  defaultVerifiedBotTimeout,
+		now:      time.Now,
+	}
+	for _, domain := range config.VerifiedBotDomains {
+		bots.domains = append(bots.domains, normalizeBotDomain(domain))
+	}
+	userAgents := config.VerifiedBotUserAgents
+	if len(userAgents) == 0 {
+		userAgents = defaultVerifiedBotUserAgents
+	}
+	for _, userAgent := range userAgents {
+		bots.userAgents = append(bots.userAgents, strings.ToLower(strings.TrimSpace(userAgent)))
+	}
+	if config.VerifiedBotCacheTTLSeconds > 0 {
+		bots.ttl = time.Duration(config.VerifiedBotCacheTTLSeconds) * time.Second
+	}
+	if config.VerifiedBotTimeoutMs > 0 {
+		bots.timeout = time.Duration(config.VerifiedBotTimeoutMs) * time.Millisecond
+	}
+
+	return bots, nil
+}
+
+// normalizeBotDomain returns the domain in lower case without leading and
+// trailing dots, e.g. ".googlebot.com." becomes "googlebot.com".
+func normalizeBotDomain(domain string) string {
+	return strings.Trim(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
+
+// claimsBot reports whether the user agent contains one of the configured
+// crawler tokens, ignoring case. Other requests are not verified.
+func (b *verifiedBots) claimsBot(userAgent string) bool {
+	userAgent = strings.ToLower(userAgent)
+	for _, token := range b.userAgents {
+		if strings.Contains(userAgent, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// verify returns the verified host name of the IP address and whether it
+// belongs to one of the domains. Results are cached, failed verifications
+// too; temporary DNS failures for a minute at most.
+func (b *verifiedBots) verify(ctx context.Context, ip net.IP) (string, bool) {
+	key := ip.String()
+	if value, ok := b.results.Get(key); ok {
+		if result := value.(botVerification); b.now().Before(result.expires) {
+			return result.host, result.verified
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, b.timeout)
+	defer cancel()
+
+	host, err := b.lookup(ctx, ip)
+	ttl := b.ttl
+	if isTemporaryDNSError(err) && ttl > verifiedBotFailureTTL {
+		ttl = verifiedBotFailureTTL
+	}
+
+	b.results.Add(key, botVerification{host: host, verified: err == nil, expires: b.now().Add(ttl)})
+	return host, err == nil
+}
+
+// lookup returns the host name of the IP address confirmed by the forward
+// lookup, or an error if the IP address is not a verified bot. A failed
+// forward lookup of one host name does not prevent the next one; its error
+// is returned if no host name is confirmed.
+func (b *verifiedBots) lookup(ctx context.Context, ip net.IP) (string, error) {
+	names, err := b.resolver.LookupAddr(ctx, ip.String())
+	if err != nil {
+		return "", err
+	}
+
+	var lookupErr error
+	for _, name := range names {
+		host := strings.ToLower(strings.TrimSuffix(name, "."))
+		if !b.matchesDomain(host) {
+			continue
+		}
+
+		addrs, err := b.resolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			lookupErr = err
+			continue
+		}
+		for _, addr := range addrs {
+			if addr.IP.Equal(ip) {
+				return host, nil
+			}
+		}
+	}
+
+	if lookupErr != nil {
+		return "", lookupErr
+	}
+	return "", fmt.Errorf("no forward-confirmed host name of the domains for [%s]", ip)
+}
+
+// matchesDomain reports whether the host is one of the domains or a
+// subdomain of them; "evilgooglebot.com" does not match "googlebot.com".
+func (b *verifiedBots) matchesDomain(host string) bool {
+	for _, domain := range b.domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func isTemporaryDNSError(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)
+}
+
+// allowVerifiedBot allows a request denied by the country rules if its user
+// agent claims to be a crawler and the IP address is a verified bot. Denied ASNs, rate limits and tarpits are not
+// lifted, neither are IP addresses denied explicitly.
+func (a *GeoBlock) allowVerifiedBot(requestIPAddr *net.IP, req *http.Request, result decision) decision {
+	if a.verifiedBots == nil || result.allowed {
+		return result
+	}
+	switch result.reason {
+	case reasonCountryNotAllowed, reasonUnknownCountry, reasonLookupFailed:
+	default:
+		return result
+	}
+
+	if !a.verifiedBots.claimsBot(req.UserAgent()) {
+		return result
+	}
+
+	host, verified := a.verifiedBots.verify(req.Context(), *requestIPAddr)
+	if !verified {
+		return result
+	}
+
+	// always logged, the denial by the country rules has been logged already
+	a.infoLogger.Printf("%s: request allowed [%s] for verified bot [%s] despite: %s",
+		a.name, requestIPAddr, host, result.reason)
+	return decision{allowed: true, reason: reasonVerifiedBot, ip: result.ip, entry: result.entry}
+}
@@ -0,0 +1,199 @@
+package geoblock
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const googlebotIP = "66.249.66.1"
+
+// fakeResolver answers from static PTR and A records and counts the lookups.
+type fakeResolver struct {
+	ptr     map[string][]string
+	hosts   map[string][]string
+	err     error
+	lookups int
+}
+
+func (r *fakeResolver) LookupAddr(_ context.Context, addr string) ([]string, error) {
+	r.lookups++
+	if r.err != nil {
+		return nil, r.err
+	}
+	names, ok := r.ptr[addr]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+	}
+	return names, nil
+}
+
+func (r *fakeResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	addrs, ok := r.hosts[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	ips := make([]net.IPAddr, 0, len(addrs))
+	for _, addr := range addrs {
+		ips = append(ips, net.IPAddr{IP: net.ParseIP(addr)})
+	}
+	return ips, nil
+}
+
+func createVerifiedBots(t *testing.T, resolver botResolver) *verifiedBots {
+	t.Helper()
+
+	bots, err := buildVerifiedBots(&Config{VerifiedBotDomains: []string{"googlebot.com", ".search.msn.com."}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bots.resolver = resolver
+	return bots
+}
+
+func TestVerifiedBotForwardConfirmed(t *testing.T) {
+	resolver := &fakeResolver{
+		ptr: map[string][]string{
+			googlebotIP:    {"crawl-66-249-66-1.googlebot.com."},
+			"157.55.39.1":  {"msnbot-157-55-39-1.search.msn.com."},
+			"192.0.2.1":    {"crawl.googlebot.com.example.org."},
+			"192.0.2.2":    {"crawl-fake.googlebot.com."},
+			"192.0.2.3":    {"evilgooglebot.com."},
+			"192.0.2.4":    {"gone.googlebot.com.", "crawl-192-0-2-4.googlebot.com."},
+			"198.51.100.1": {"host.example.org."},
+		},
+		hosts: map[string][]string{
+			"crawl-66-249-66-1.googlebot.com":   {googlebotIP},
+			"msnbot-157-55-39-1.search.msn.com": {"157.55.39.1"},
+			"crawl.googlebot.com.example.org":   {"192.0.2.1"},
+			"crawl-fake.googlebot.com":          {googlebotIP}, // spoofed PTR record
+			"evilgooglebot.com":                 {"192.0.2.3"},
+			"crawl-192-0-2-4.googlebot.com":     {"192.0.2.4"},
+		},
+	}
+	bots := createVerifiedBots(t, resolver)
+
+	for ip, expected := range map[string]bool{
+		googlebotIP:    true,
+		"157.55.39.1":  true,
+		"192.0.2.1":    false, // suffix of another domain
+		"192.0.2.2":    false, // forward lookup does not match
+		"192.0.2.3":    false, // not a subdomain
+		"192.0.2.4":    true,  // first host name does not resolve
+		"198.51.100.1": false,
+		"203.0.113.1":  false, // no PTR record
+	} {
+		if _, verified := bots.verify(context.Background(), net.ParseIP(ip)); verified != expected {
+			t.Errorf("%s: expected verified %t, got %t", ip, expected, verified)
+		}
+	}
+}
+
+func TestVerifiedBotCache(t *testing.T) {
+	resolver := &fakeResolver{
+		ptr:   map[string][]string{googlebotIP: {"crawl-66-249-66-1.googlebot.com."}},
+		hosts: map[string][]string{"crawl-66-249-66-1.googlebot.com": {googlebotIP}},
+	}
+	bots := createVerifiedBots(t, resolver)
+	now := time.Now()
+	bots.now = func() time.Time { return now }
+
+	for _, ip := range []string{googlebotIP, googlebotIP, "203.0.113.1", "203.0.113.1"} {
+		bots.verify(context.Background(), net.ParseIP(ip))
+	}
+	if resolver.lookups != 2 {
+		t.Fatalf("expected 2 lookups, got %d", resolver.lookups)
+	}
+
+	now = now.Add(bots.ttl)
+	if host, verified := bots.verify(context.Background(), net.ParseIP(googlebotIP)); !verified ||
+		host != "crawl-66-249-66-1.googlebot.com" {
+		t.Fatalf("expected verified host, got %q %t", host, verified)
+	}
+	if resolver.lookups != 3 {
+		t.Fatalf("expected expired result to be looked up again, got %d lookups", resolver.lookups)
+	}
+}
+
+func TestVerifiedBotTemporaryErrorCachedBriefly(t *testing.T) {
+	resolver := &fakeResolver{err: &net.DNSError{Err: "timeout", IsTimeout: true}}
+	bots := createVerifiedBots(t, resolver)
+	now := time.Now()
+	bots.now = func() time.Time { return now }
+
+	bots.verify(context.Background(), net.ParseIP(googlebotIP))
+	bots.verify(context.Background(), net.ParseIP(googlebotIP))
+	if resolver.lookups != 1 {
+		t.Fatalf("expected temporary error to be cached, got %d lookups", resolver.lookups)
+	}
+
+	now = now.Add(verifiedBotFailureTTL)
+	bots.verify(context.Background(), net.ParseIP(googlebotIP))
+	if resolver.lookups != 2 {
+		t.Fatalf("expected temporary error to expire early, got %d lookups", resolver.lookups)
+	}
+}
+
+func TestVerifiedBotDecision(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		_, _ = rw.Write([]byte("US"))
+	}))
+	defer server.Close()
+
+	config := CreateConfig()
+	config.API = server.URL + "/{ip}"
+	config.CacheSize = 10
+	config.SilentStartUp = true
+	config.Countries = []string{"CH"}
+	config.VerifiedBotDomains = []string{"googlebot.com"}
+
+	handler, err := New(context.Background(), http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}),
+		config, t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.(*GeoBlock).verifiedBots.resolver = &fakeResolver{
+		ptr:   map[string][]string{googlebotIP: {"crawl-66-249-66-1.googlebot.com."}},
+		hosts: map[string][]string{"crawl-66-249-66-1.googlebot.com": {googlebotIP}},
+	}
+
+	googlebot := "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+	for name, test := range map[string]struct {
+		ip        string
+		userAgent string
+		expected  int
+	}{
+		"verified":       {googlebotIP, googlebot, http.StatusOK},
+		"not verified":   {"203.0.113.1", googlebot, http.StatusForbidden},
+		"no bot claimed": {googlebotIP, "Mozilla/5.0", http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.Header.Set("X-Forwarded-For", test.ip)
+		req.Header.Set("User-Agent", test.userAgent)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != test.expected {
+			t.Errorf("%s: expected status %d, got %d", name, test.expected, recorder.Code)
+		}
+	}
+}
+
+func TestInvalidVerifiedBotConfig(t *testing.T) {
+	for name, config := range map[string]*Config{
+		"domain":     {VerifiedBotDomains: []string{"googlebot"}},
+		"wildcard":   {VerifiedBotDomains: []string{"*.googlebot.com"}},
+		"url":        {VerifiedBotDomains: []string{"https://googlebot.com"}},
+		"cache size": {VerifiedBotDomains: []string{"googlebot.com"}, VerifiedBotCacheSize: 1},
+		"cache TTL":  {VerifiedBotDomains: []string{"googlebot.com"}, VerifiedBotCacheTTLSeconds: -1},
+		"timeout":    {VerifiedBotDomains: []string{"googlebot.com"}, VerifiedBotTimeoutMs: -1},
+		"user agent": {VerifiedBotDomains: []string{"googlebot.com"}, VerifiedBotUserAgents: []string{" "}},
+	} {
+		if errs := validateVerifiedBotConfig(config); len(errs) == 0 {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}